@@ -1,29 +1,1075 @@
 package configuration
 
-const BotChannel = "#foulab"
-const BotNick = "foubot"
-const BotPswd = "HAHAHAHAHA, nope."
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
 
-// Controls auto-voicing and the !vox command
-const BotAutoVoice = false
-const ServerTLS = "irc.libera.chat:6697"
+// DefaultPath is where the configuration file is read from unless the -config
+// flag says otherwise.
+const DefaultPath = "/etc/foubot2/config.json"
 
-// Set topic through chanserv instead of directly, avoids
-// the need for the +o mode but requires chanserv flag +t
-const TopicUseChanserv = true
+type Config struct {
+	IRC        IRC        `json:"irc"`
+	Topic      Topic      `json:"topic"`
+	Calendar   Calendar   `json:"calendar"`
+	Mattermost Mattermost `json:"mattermost"`
+	Messages   Messages   `json:"messages"`
+	GPIO       GPIO       `json:"gpio"`
+	Doorbell   Doorbell   `json:"doorbell"`
+	HTTP       HTTP       `json:"http"`
+	SpaceAPI   SpaceAPI   `json:"spaceapi"`
+	Stats      Stats      `json:"stats"`
+	Override   Override   `json:"override"`
+	Nag        Nag        `json:"nag"`
+	Hooks      []Hook     `json:"hooks"`
+	Webhooks   []Webhook  `json:"webhooks"`
 
-// Whether to send status updates as normal messages along
-// with updating the topic
-const TopicSendToChannel = false
-const StatusEndPoint = "https://foulab.org/YTDMOWI3N2MXNMEZYWE4MGRHYTRLMZC4NJU5MJI2ZJYYODMYNME5NSAGLQO/"
-const Blinker = "http://blinker.lab/"
+	// The outputs told about the lab status and calendar events, in order,
+	// some of SinkNames. Each is skipped if its own settings are empty (eg.
+	// mattermost.server, status_endpoint).
+	Sinks []string `json:"sinks"`
+	Retry Retry    `json:"retry"`
 
-const CalendarURL = "https://foulab.org/ical/foulab.ics"
+	// How long each sink may take to deliver an update, by name. Sinks not
+	// listed get DefaultSinkTimeout.
+	SinkTimeouts map[string]Duration `json:"sink_timeouts"`
 
-// If set, update Mattermost channel header with the status of the lab
+	// The last lab status is kept here across restarts. Empty to disable.
+	StateFile string `json:"state_file"`
+
+	// Every change of lab status is appended here, for !history. Empty to
+	// disable.
+	HistoryFile string `json:"history_file"`
+
+	// Contains a secret path, so it is a Secret as a whole.
+	StatusEndPoint Secret  `json:"status_endpoint"`
+	Blinker        Blinker `json:"blinker"`
+	Melody         Melody  `json:"melody"`
+}
+
+type IRC struct {
+	Server   string `json:"server"`
+	Channel  string `json:"channel"`
+	Nick     string `json:"nick"`
+	Password Secret `json:"password"`
+
+	// Controls auto-voicing and the !vox command
+	AutoVoice bool `json:"auto_voice"`
+}
+
+type Topic struct {
+	// Set topic through chanserv instead of directly, avoids
+	// the need for the +o mode but requires chanserv flag +t
+	UseChanserv bool `json:"use_chanserv"`
+
+	// Whether to send status updates as normal messages along
+	// with updating the topic
+	SendToChannel bool `json:"send_to_channel"`
+
+	// Show when the status began: "|| LAB OPEN since 19:42 ||".
+	ShowSince bool `json:"show_since"`
+}
+
+type Calendar struct {
+	URL      string   `json:"url"`
+	Interval Duration `json:"interval"`
+}
+
+// If Server is set, update Mattermost channel header with the status of the lab
 // (open or closed).
-const MattermostServer = ""
-const MattermostChannelId = ""
+type Mattermost struct {
+	Server    string `json:"server"`
+	ChannelID string `json:"channel_id"`
+
+	// https://developers.mattermost.com/integrate/reference/personal-access-token/
+	Token Secret `json:"token"`
+}
+
+type GPIO struct {
+	// "rpio" for the Raspberry Pi through /dev/gpiomem, "cdev" for the Linux
+	// GPIO character device (any board, edge-driven), or "fake" to run without
+	// GPIO hardware.
+	Backend string `json:"backend"`
+
+	// For "cdev", eg. "/dev/gpiochip0".
+	Chip string `json:"chip"`
+
+	// How long an input must keep a new level before it counts; shorter
+	// changes are ignored as glitches.
+	Debounce Duration `json:"debounce"`
+
+	Pins Pins `json:"pins"`
+}
+
+// Pins are the GPIO pins in use, by role. Inputs have fixed roles: "button"
+// (the Big Red Button, active when the lab is open) and "doorbell" (active
+// while pressed). Outputs can have any name.
+//
+// If present in the configuration file, Pins replaces the default pins as a
+// whole.
+type Pins map[string]Pin
+
+func (p *Pins) UnmarshalJSON(b []byte) error {
+	m := make(map[string]Pin)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*p = m
+	return nil
+}
+
+// InputRoles are the roles an input pin can have.
+var InputRoles = []string{"button", "doorbell"}
+
+type Pin struct {
+	// BCM GPIO number (not the header pin number), or the line offset on the
+	// chip for the "cdev" backend.
+	Pin int `json:"pin"`
+
+	// "input" or "output".
+	Direction string `json:"direction"`
+
+	// The pin is low when active (button pressed, LED lit).
+	ActiveLow bool `json:"active_low"`
+
+	// Inputs: bias, "up", "down" or "none".
+	Pull string `json:"pull"`
+
+	// Outputs: whether the output is active at startup.
+	Initial bool `json:"initial"`
+
+	// Outputs: "open" to be active while the lab is open. Empty to stay as
+	// set by Initial (eg. a ground for an LED).
+	Follows string `json:"follows"`
+}
+
+// Announced when the "doorbell" input is pressed.
+type Doorbell struct {
+	// Presses within Cooldown of the last announcement are only logged.
+	Cooldown Duration `json:"cooldown"`
+}
+
+// The built-in HTTP server.
+type HTTP struct {
+	// Address to listen on, eg. ":8080" or "127.0.0.1:8080". Empty to disable
+	// the HTTP server.
+	Listen string `json:"listen"`
+}
+
+// Static information about the space, served with the live lab status as a
+// SpaceAPI document (https://spaceapi.io) at /spaceapi.json.
+type SpaceAPI struct {
+	// The name of the space. Empty to disable /spaceapi.json.
+	Space string `json:"space"`
+	Logo  string `json:"logo"`
+	URL   string `json:"url"`
+
+	Location SpaceLocation `json:"location"`
+
+	// SpaceAPI contact fields, eg. "email", "irc", "mastodon", "ml".
+	Contact map[string]string `json:"contact"`
+
+	// Optional icons for the open and closed states.
+	IconOpen   string `json:"icon_open"`
+	IconClosed string `json:"icon_closed"`
+
+	Projects []string `json:"projects"`
+}
+
+type SpaceLocation struct {
+	Address string  `json:"address"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+
+	// eg. "America/Montreal".
+	Timezone string `json:"timezone"`
+}
+
+// A summary of how long the lab was open (!stats), posted to IRC and
+// Mattermost.
+type Stats struct {
+	// When to post it, eg. "Mon 09:00". Empty to only answer !stats.
+	Schedule Schedule `json:"schedule"`
+
+	// The period it covers, ending at the last midnight: "week" or "month"
+	// (30 days).
+	Period string `json:"period"`
+}
+
+// Who may set the lab status by hand with !open, !close and !auto, when the
+// button is broken or forgotten.
+type Override struct {
+	// IRC hostmasks, nick!user@host, where * matches anything and ? any one
+	// character, eg. "*!*@user/alice". Nobody if empty.
+	Trusted []string `json:"trusted"`
+}
+
+// Allows reports whether source (nick!user@host) matches one of the trusted
+// hostmasks. Case is ignored.
+func (o Override) Allows(source string) bool {
+	for _, mask := range o.Trusted {
+		if matchMask(strings.ToLower(mask), strings.ToLower(source)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMask matches s against an IRC mask with * and ? wildcards.
+func matchMask(mask, s string) bool {
+	if mask == "" {
+		return s == ""
+	}
+	switch mask[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if matchMask(mask[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && matchMask(mask[1:], s[1:])
+	}
+	return s != "" && s[0] == mask[0] && matchMask(mask[1:], s[1:])
+}
+
+// Reminds IRC and Mattermost when the lab looks like it was left open by
+// mistake, until it is closed or someone says !ack.
+type Nag struct {
+	// Remind if the lab is still open at this time of day, eg. "23:30".
+	// Empty to not check the time.
+	After TimeOfDay `json:"after"`
+
+	// Remind if the lab has been open for longer than this. Zero to not
+	// check.
+	OpenFor Duration `json:"open_for"`
+
+	// Remind again this often. Zero to remind only once.
+	Repeat Duration `json:"repeat"`
+}
+
+// SinkNames are the outputs which can be listed in Config.Sinks:
+//
+//	irc         the status and next event in the topic, announcements,
+//	            calendar events
+//	mattermost  the status and next event in the channel header, calendar
+//	            events, the doorbell and other messages
+//	website     status_endpoint + "OPEN" or "CLOSED"
+//	blinker     switched on while the lab is open
+//	melody      Mopidy calls when the lab opens or closes, or an event starts
+var SinkNames = []string{"irc", "mattermost", "website", "blinker", "melody"}
+
+// Retry is how failed status updates of sinks are tried again. Only the
+// latest status is delivered.
+type Retry struct {
+	// Updates still to deliver are kept here across restarts. Empty to keep
+	// them only in memory.
+	File string `json:"file"`
+
+	// The first retry is after Min, then twice as long every time, up to
+	// Max.
+	Min Duration `json:"min"`
+	Max Duration `json:"max"`
+}
+
+// DefaultSinkTimeout is the timeout of sinks and webhooks which don't set
+// their own.
+const DefaultSinkTimeout = 10 * time.Second
+
+// SinkTimeout is how long the sink called name may take to deliver an update.
+func (c *Config) SinkTimeout(name string) time.Duration {
+	if t, ok := c.SinkTimeouts[name]; ok {
+		return time.Duration(t)
+	}
+	return DefaultSinkTimeout
+}
+
+// SinkEnabled tells whether name is listed in c.Sinks.
+func (c *Config) SinkEnabled(name string) bool {
+	return contains(c.Sinks, name)
+}
+
+// HookEvents are the events a hook can run on.
+var HookEvents = []string{"open", "closed", "doorbell", "event_start"}
+
+// Hook is an executable run on some events, eg. to switch something on when
+// the lab opens. It gets the environment of foubot2, plus:
+//
+//	FOUBOT2_EVENT           the event: "open", "closed", "doorbell" or "event_start"
+//	FOUBOT2_STATUS          the lab status, "OPEN" or "CLOSED"
+//	FOUBOT2_SINCE           when that status began (RFC 3339)
+//	FOUBOT2_SOURCE          what set it: "button" or "override"
+//	FOUBOT2_CALENDAR_EVENT  for "event_start", the calendar event
+//
+// Its output is logged.
+type Hook struct {
+	// For the logs. Defaults to the name of the executable.
+	Name string `json:"name"`
+
+	// The executable and its arguments, eg. ["/usr/local/bin/lights", "on"].
+	// Not run through a shell.
+	Command []string `json:"command"`
+
+	// Some of HookEvents.
+	On []string `json:"on"`
+
+	// The hook is killed after this long. Zero for 30s.
+	Timeout Duration `json:"timeout"`
+}
+
+// RunsOn tells whether event is listed in h.On.
+func (h Hook) RunsOn(event string) bool {
+	return contains(h.On, event)
+}
+
+// Blinker is a Tasmota device (a plug or a light) which is on while the lab is
+// open. In the configuration file, a string is short for {"url": ...}.
+type Blinker struct {
+	// Eg. "http://blinker.lab/". Empty to disable.
+	URL string `json:"url"`
+
+	// The web admin password of the device, if it has one.
+	Password Secret `json:"password"`
+
+	// Tasmota commands sent after switching it on when the lab opens, or off
+	// when it closes, eg. ["Color 255,0,0", "Dimmer 50"], see
+	// https://tasmota.github.io/docs/Commands/.
+	Open   []string `json:"open"`
+	Closed []string `json:"closed"`
+
+	// How often to check that the device is on or off as it should be, and
+	// set it again if not (eg. after a power cut). Zero to not check.
+	Check Duration `json:"check"`
+}
+
+func (b *Blinker) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		b.URL = s
+		return nil
+	}
+
+	// Decoding on top of b keeps the defaults.
+	type alias Blinker
+	if err := decodeStrict(data, (*alias)(b)); err != nil {
+		// Offsets would be relative to the blinker, not the file.
+		return fmt.Errorf("blinker: %s", err)
+	}
+	return nil
+}
+
+// Melody is the Mopidy music server of the lab, and what it is asked to do
+// when the lab opens or closes, or a calendar event starts.
+type Melody struct {
+	// Its JSON-RPC endpoint, eg. "http://melody/mopidy/rpc". Empty to
+	// disable.
+	URL string `json:"url"`
+
+	Open       []MopidyCall `json:"open"`
+	Closed     []MopidyCall `json:"closed"`
+	EventStart []MopidyCall `json:"event_start"`
+}
+
+// MopidyCall is a call of the Mopidy JSON-RPC API, eg.
+//
+//	{"method": "core.mixer.set_volume", "params": {"volume": 30}}
+//
+// see https://docs.mopidy.com/en/latest/api/core/.
+type MopidyCall struct {
+	Method string `json:"method"`
+	// An object of named parameters, or an array. Empty for none.
+	Params json.RawMessage `json:"params"`
+}
+
+// Webhook is an HTTP request sent on some events, eg. to update a website.
+// The body is rendered from Body, with the placeholders:
+//
+//	{{.Event}}          the event: "open", "closed", "doorbell" or "event_start"
+//	{{.Status}}         the lab status, "OPEN" or "CLOSED"
+//	{{.Open}}           true or false
+//	{{.Since}}          when that status began (RFC 3339)
+//	{{.Source}}         what set it: "button" or "override"
+//	{{.CalendarEvent}}  for "event_start", the calendar event
+//	{{.Time}}           when the request was sent (RFC 3339)
+//
+// Strings must go through json to be quoted, eg. {"status": {{json .Status}}}.
+//
+// If Secret is set, the request has a header
+//
+//	X-Foubot2-Signature: sha256=<hex HMAC-SHA256 of the body with Secret>
+//
+// so that the receiver can check it came from us.
+type Webhook struct {
+	// For the logs and /api/sinks, as "webhook_<name>". Required.
+	Name string `json:"name"`
+
+	// Some of HookEvents.
+	On []string `json:"on"`
+
+	// "POST" (the default), "PUT" or "PATCH".
+	Method string `json:"method"`
+
+	// May contain a secret path or token, so it is a Secret as a whole.
+	URL Secret `json:"url"`
+
+	// Extra request headers, eg. {"Authorization": {"env": "FOUBOT2_WEBHOOK_TOKEN"}}.
+	// Content-Type is application/json unless set here.
+	Headers map[string]Secret `json:"headers"`
+
+	// Defaults to DefaultWebhookBody. Must render to JSON, unless a
+	// Content-Type header is set.
+	Body Template `json:"body"`
+
+	// Key to sign the body with. Empty to not sign.
+	Secret Secret `json:"secret"`
+
+	// The request is abandoned after this long. Zero for DefaultSinkTimeout.
+	Timeout Duration `json:"timeout"`
+}
+
+// DefaultWebhookBody has all the placeholders of Webhook.
+var DefaultWebhookBody = mustTemplate(`{"event": {{json .Event}}, "status": {{json .Status}}, "open": {{.Open}}, "since": {{json .Since}}, "source": {{json .Source}}, "calendar_event": {{json .CalendarEvent}}, "time": {{json .Time}}}`)
+
+// WebhookPlaceholders are the placeholders available in Webhook.Body.
+var WebhookPlaceholders = []string{"Event", "Status", "Open", "Since", "Source", "CalendarEvent", "Time"}
+
+// RunsOn tells whether event is listed in w.On.
+func (w Webhook) RunsOn(event string) bool {
+	return contains(w.On, event)
+}
+
+func (w *Webhook) UnmarshalJSON(b []byte) error {
+	type alias Webhook
+	v := alias{Method: "POST", Body: DefaultWebhookBody}
+	if err := decodeStrict(b, &v); err != nil {
+		// Offsets would be relative to the webhook, not the file.
+		return fmt.Errorf("webhook: %s", err)
+	}
+	*w = Webhook(v)
+	return nil
+}
+
+type Messages struct {
+	// Replies to !status. Placeholders: {{.Since}} (eg. "19:42"),
+	// {{.Duration}} (eg. "2h10m")
+	Open   Template `json:"open"`
+	Closed Template `json:"closed"`
+
+	// Sent to the channel when the status changes, if topic.send_to_channel
+	// is set. Placeholders: {{.Status}}
+	Announcement Template `json:"announcement"`
+
+	// Sent to IRC and Mattermost when a calendar event starts.
+	// Placeholders: {{.Event}}
+	StartingEvent Template `json:"starting_event"`
+
+	// Sent to IRC and Mattermost when the doorbell is pressed.
+	Doorbell Template `json:"doorbell"`
+
+	// Sent to IRC and Mattermost when the lab may have been left open, see
+	// Nag. Placeholders: {{.Since}}, {{.Duration}}
+	Nag Template `json:"nag"`
+}
+
+// Duration is a time.Duration written as a string in the configuration file,
+// eg. "90s" or "1h30m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"90s\" or \"1h30m\", got %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Default returns the configuration used for any setting that is not present
+// in the configuration file.
+func Default() *Config {
+	return &Config{
+		IRC: IRC{
+			Server:   "irc.libera.chat:6697",
+			Channel:  "#foulab",
+			Nick:     "foubot",
+			Password: defaultSecret("irc_password"),
+		},
+		Topic: Topic{
+			UseChanserv: true,
+		},
+		Calendar: Calendar{
+			URL:      "https://foulab.org/ical/foulab.ics",
+			Interval: Duration(60 * time.Minute),
+		},
+		Mattermost: Mattermost{
+			Token: defaultSecret("mattermost_token"),
+		},
+		Messages: Messages{
+			Open:          mustTemplate("The lab is currently OPEN since {{.Since}} ({{.Duration}})."),
+			Closed:        mustTemplate("Sadly, the lab is currently CLOSED since {{.Since}} ({{.Duration}})."),
+			Announcement:  mustTemplate("|| LAB {{.Status}} ||"),
+			StartingEvent: mustTemplate("Starting event: {{.Event}}"),
+			Doorbell:      mustTemplate("Someone is at the door"),
+			Nag:           mustTemplate("The lab is still OPEN since {{.Since}} ({{.Duration}}). Did someone forget the button? Close the lab, or say !ack to stop these reminders."),
+		},
+		StateFile:      "/var/lib/foubot2/state.json",
+		HistoryFile:    "/var/lib/foubot2/history.jsonl",
+		StatusEndPoint: defaultSecret("status_endpoint"),
+		Blinker: Blinker{
+			URL:   "http://blinker.lab/",
+			Check: Duration(5 * time.Minute),
+		},
+		Melody: Melody{
+			URL:    "http://melody/mopidy/rpc",
+			Closed: []MopidyCall{{Method: "core.playback.stop"}},
+		},
+		// A copy: decoding the configuration reuses the array.
+		Sinks: append([]string(nil), SinkNames...),
+		Retry: Retry{
+			File: "/var/lib/foubot2/retries.json",
+			Min:  Duration(10 * time.Second),
+			Max:  Duration(10 * time.Minute),
+		},
+		Doorbell: Doorbell{
+			Cooldown: Duration(time.Minute),
+		},
+		Stats: Stats{
+			Period: "week",
+		},
+		Nag: Nag{
+			Repeat: Duration(time.Hour),
+		},
+		GPIO: GPIO{
+			Backend:  "rpio",
+			Chip:     "/dev/gpiochip0",
+			Debounce: Duration(100 * time.Millisecond),
+			Pins: Pins{
+				"button": {Pin: 23, Direction: "input", Pull: "up"},
+				"status": {Pin: 24, Direction: "output", Follows: "open"},
+				// Downstairs "Open" LED
+				"open_led":        {Pin: 17, Direction: "output", Follows: "open"},
+				"open_led_ground": {Pin: 21, Direction: "output"},
+			},
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of Default and validates
+// the result. An empty path uses the defaults, and secrets from the
+// environment.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		return Parse([]byte("{}"))
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Parse decodes a JSON configuration on top of Default, reads the secrets it
+// refers to and validates the result. Unknown keys are rejected, so that typos
+// don't silently fall back to a default.
+func Parse(b []byte) (*Config, error) {
+	c := Default()
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, describeJSONError(b, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level object")
+	}
+	if err := c.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func describeJSONError(b []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		if e.Field != "" {
+			return fmt.Errorf("line %d: %s: cannot use %s as %s", lineOf(b, e.Offset), e.Field, e.Value, e.Type)
+		}
+		offset = e.Offset
+	default:
+		// Unknown fields and errors from UnmarshalJSON carry no offset.
+		return err
+	}
+	return fmt.Errorf("line %d: %s", lineOf(b, offset), err)
+}
+
+func lineOf(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
+
+// Validate checks the configuration for values which can't work, and reports
+// all of them at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", field, err))
+		}
+	}
+
+	check("irc.server", validateHostPort(c.IRC.Server))
+	if !strings.HasPrefix(c.IRC.Channel, "#") && !strings.HasPrefix(c.IRC.Channel, "&") {
+		check("irc.channel", fmt.Errorf("%q must start with # or &", c.IRC.Channel))
+	}
+	if c.IRC.Nick == "" || strings.ContainsAny(c.IRC.Nick, " ,*?!@") {
+		check("irc.nick", fmt.Errorf("%q is not a valid nickname", c.IRC.Nick))
+	}
+
+	check("calendar.url", validateURL(c.Calendar.URL, true))
+	if c.Calendar.Interval < Duration(time.Minute) {
+		check("calendar.interval", fmt.Errorf("%s is shorter than 1m", c.Calendar.Interval))
+	}
+
+	if c.Mattermost.Server != "" {
+		check("mattermost.server", validateURL(c.Mattermost.Server, true))
+		if c.Mattermost.ChannelID == "" {
+			check("mattermost.channel_id", fmt.Errorf("required when mattermost.server is set"))
+		}
+		if c.Mattermost.Token.Value() == "" {
+			check("mattermost.token", fmt.Errorf("required when mattermost.server is set"))
+		}
+	}
+
+	// The lab status ("OPEN" / "CLOSED") is appended to these.
+	check("status_endpoint", validateSecretURL(c.StatusEndPoint, false))
+	check("blinker.url", validateURL(c.Blinker.URL, false))
+	for i, cmd := range append(c.Blinker.Open, c.Blinker.Closed...) {
+		if strings.TrimSpace(cmd) == "" {
+			field := fmt.Sprintf("blinker.open[%d]", i)
+			if i >= len(c.Blinker.Open) {
+				field = fmt.Sprintf("blinker.closed[%d]", i-len(c.Blinker.Open))
+			}
+			check(field, fmt.Errorf("empty command"))
+		}
+	}
+	check("melody.url", validateURL(c.Melody.URL, false))
+	for _, calls := range []struct {
+		field string
+		calls []MopidyCall
+	}{
+		{"melody.open", c.Melody.Open},
+		{"melody.closed", c.Melody.Closed},
+		{"melody.event_start", c.Melody.EventStart},
+	} {
+		for i, call := range calls.calls {
+			check(fmt.Sprintf("%s[%d]", calls.field, i), validateMopidyCall(call))
+		}
+	}
+	if c.Blinker.Check < 0 || c.Blinker.Check > 0 && c.Blinker.Check < Duration(10*time.Second) {
+		check("blinker.check", fmt.Errorf("%s must be 0 or at least 10s", c.Blinker.Check))
+	}
+
+	switch c.GPIO.Backend {
+	case "rpio", "fake":
+	case "cdev":
+		if c.GPIO.Chip == "" {
+			check("gpio.chip", fmt.Errorf("required when gpio.backend is \"cdev\""))
+		}
+	default:
+		check("gpio.backend", fmt.Errorf("%q is not one of \"rpio\", \"cdev\", \"fake\"", c.GPIO.Backend))
+	}
+
+	if c.GPIO.Debounce < 0 {
+		check("gpio.debounce", fmt.Errorf("must not be negative"))
+	}
+	validatePins(c.GPIO.Pins, check)
+
+	check("messages.open", c.Messages.Open.validate("Since", "Duration"))
+	check("messages.closed", c.Messages.Closed.validate("Since", "Duration"))
+	check("messages.announcement", c.Messages.Announcement.validate("Status"))
+	check("messages.starting_event", c.Messages.StartingEvent.validate("Event"))
+	check("messages.doorbell", c.Messages.Doorbell.validate())
+	check("messages.nag", c.Messages.Nag.validate("Since", "Duration"))
+
+	if c.Doorbell.Cooldown < 0 {
+		check("doorbell.cooldown", fmt.Errorf("must not be negative"))
+	}
+
+	if c.Nag.OpenFor < 0 {
+		check("nag.open_for", fmt.Errorf("must not be negative"))
+	}
+	if c.Nag.Repeat < 0 || c.Nag.Repeat > 0 && c.Nag.Repeat < Duration(time.Minute) {
+		check("nag.repeat", fmt.Errorf("%s must be 0 or at least 1m", c.Nag.Repeat))
+	}
+
+	for i, h := range c.Hooks {
+		field := fmt.Sprintf("hooks[%d]", i)
+		if len(h.Command) == 0 || h.Command[0] == "" {
+			check(field+".command", fmt.Errorf("required"))
+		}
+		if len(h.On) == 0 {
+			check(field+".on", fmt.Errorf("required, some of %q", HookEvents))
+		}
+		for _, on := range h.On {
+			if !contains(HookEvents, on) {
+				check(field+".on", fmt.Errorf("%q is not one of %q", on, HookEvents))
+			}
+		}
+		if h.Timeout < 0 {
+			check(field+".timeout", fmt.Errorf("must not be negative"))
+		}
+	}
+
+	for i, name := range c.Sinks {
+		if !contains(SinkNames, name) {
+			check(fmt.Sprintf("sinks[%d]", i), fmt.Errorf("%q is not one of %q", name, SinkNames))
+		} else if contains(c.Sinks[:i], name) {
+			check(fmt.Sprintf("sinks[%d]", i), fmt.Errorf("%q is listed twice", name))
+		}
+	}
+
+	var timeouts []string
+	for name := range c.SinkTimeouts {
+		timeouts = append(timeouts, name)
+	}
+	sort.Strings(timeouts)
+	for _, name := range timeouts {
+		if !contains(SinkNames, name) {
+			check("sink_timeouts", fmt.Errorf("%q is not one of %q", name, SinkNames))
+		} else if c.SinkTimeouts[name] <= 0 {
+			check("sink_timeouts."+name, fmt.Errorf("must be positive"))
+		}
+	}
+
+	if c.Retry.Min < Duration(time.Second) {
+		check("retry.min", fmt.Errorf("%s is shorter than 1s", c.Retry.Min))
+	}
+	if c.Retry.Max < c.Retry.Min {
+		check("retry.max", fmt.Errorf("%s is shorter than retry.min", c.Retry.Max))
+	}
+
+	names := make(map[string]bool)
+	for i, w := range c.Webhooks {
+		field := fmt.Sprintf("webhooks[%d]", i)
+		if w.Name == "" {
+			check(field+".name", fmt.Errorf("required"))
+		} else if names[w.Name] {
+			check(field+".name", fmt.Errorf("%q is already used", w.Name))
+		}
+		names[w.Name] = true
+		validateWebhook(&w, field, check)
+	}
+
+	for i, mask := range c.Override.Trusted {
+		if !strings.Contains(mask, "!") || !strings.Contains(mask, "@") {
+			check(fmt.Sprintf("override.trusted[%d]", i), fmt.Errorf("%q must be like nick!user@host", mask))
+		}
+	}
+
+	switch c.Stats.Period {
+	case "week", "month":
+	default:
+		check("stats.period", fmt.Errorf("%q is not one of \"week\", \"month\"", c.Stats.Period))
+	}
+
+	if c.HTTP.Listen != "" {
+		check("http.listen", validateHostPort(c.HTTP.Listen))
+	}
+	if c.SpaceAPI.Space != "" {
+		validateSpaceAPI(&c.SpaceAPI, check)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func validatePins(pins Pins, check func(field string, err error)) {
+	if _, ok := pins["button"]; !ok {
+		check("gpio.pins", fmt.Errorf("\"button\" is required"))
+	}
+
+	var roles []string
+	for role := range pins {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	used := make(map[int]string)
+	for _, role := range roles {
+		p := pins[role]
+		field := "gpio.pins." + role
+		if p.Pin < 0 {
+			check(field+".pin", fmt.Errorf("must not be negative"))
+		}
+		if other, ok := used[p.Pin]; ok {
+			check(field+".pin", fmt.Errorf("%d is already used by %q", p.Pin, other))
+		}
+		used[p.Pin] = role
+
+		switch p.Direction {
+		case "input":
+			if !contains(InputRoles, role) {
+				check(field, fmt.Errorf("unknown input role, must be one of %q", InputRoles))
+			}
+			switch p.Pull {
+			case "up", "down", "none":
+			default:
+				check(field+".pull", fmt.Errorf("%q is not one of \"up\", \"down\", \"none\"", p.Pull))
+			}
+			if p.Follows != "" || p.Initial {
+				check(field, fmt.Errorf("\"follows\" and \"initial\" are only for outputs"))
+			}
+		case "output":
+			if contains(InputRoles, role) {
+				check(field+".direction", fmt.Errorf("must be \"input\""))
+			}
+			if p.Pull != "" {
+				check(field+".pull", fmt.Errorf("only for inputs"))
+			}
+			if p.Follows != "" && p.Follows != "open" {
+				check(field+".follows", fmt.Errorf("%q is not one of \"\", \"open\"", p.Follows))
+			}
+		default:
+			check(field+".direction", fmt.Errorf("%q is not one of \"input\", \"output\"", p.Direction))
+		}
+	}
+}
+
+func validateWebhook(w *Webhook, field string, check func(field string, err error)) {
+	if len(w.On) == 0 {
+		check(field+".on", fmt.Errorf("required, some of %q", HookEvents))
+	}
+	for _, on := range w.On {
+		if !contains(HookEvents, on) {
+			check(field+".on", fmt.Errorf("%q is not one of %q", on, HookEvents))
+		}
+	}
+	if w.Timeout < 0 {
+		check(field+".timeout", fmt.Errorf("must not be negative"))
+	}
+	switch w.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		check(field+".method", fmt.Errorf("%q is not one of \"POST\", \"PUT\", \"PATCH\"", w.Method))
+	}
+	check(field+".url", validateSecretURL(w.URL, true))
+	contentType := false
+	for name := range w.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			check(field+".headers", fmt.Errorf("%q is not a valid header name", name))
+		}
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = true
+		}
+	}
+
+	if err := w.Body.validate(WebhookPlaceholders...); err != nil {
+		check(field+".body", err)
+		return
+	}
+	if !contentType {
+		body, err := w.Body.execute(map[string]string{
+			"Event":         "open",
+			"Status":        "OPEN",
+			"Open":          "true",
+			"Since":         "2006-01-02T15:04:05Z",
+			"Source":        "button",
+			"CalendarEvent": "",
+			"Time":          "2006-01-02T15:04:05Z",
+		})
+		if err == nil && !json.Valid([]byte(body)) {
+			check(field+".body", fmt.Errorf("does not render to JSON, eg. %s (set a Content-Type header to send something else)", body))
+		}
+	}
+}
+
+func validateMopidyCall(call MopidyCall) error {
+	if !strings.HasPrefix(call.Method, "core.") {
+		return fmt.Errorf("method %q must be like \"core.playback.stop\"", call.Method)
+	}
+	params := bytes.TrimSpace(call.Params)
+	if len(params) > 0 && params[0] != '{' && params[0] != '[' {
+		return fmt.Errorf("params must be an object or an array (left out for none), got %s", params)
+	}
+	return nil
+}
+
+func validateSpaceAPI(s *SpaceAPI, check func(field string, err error)) {
+	check("spaceapi.logo", validateURL(s.Logo, true))
+	check("spaceapi.url", validateURL(s.URL, true))
+	check("spaceapi.icon_open", validateURL(s.IconOpen, s.IconClosed != ""))
+	check("spaceapi.icon_closed", validateURL(s.IconClosed, s.IconOpen != ""))
+
+	l := s.Location
+	if l.Lat == 0 && l.Lon == 0 {
+		check("spaceapi.location", fmt.Errorf("lat and lon are required"))
+	}
+	if l.Lat < -90 || l.Lat > 90 {
+		check("spaceapi.location.lat", fmt.Errorf("%g is not between -90 and 90", l.Lat))
+	}
+	if l.Lon < -180 || l.Lon > 180 {
+		check("spaceapi.location.lon", fmt.Errorf("%g is not between -180 and 180", l.Lon))
+	}
+	if l.Timezone != "" {
+		if _, err := time.LoadLocation(l.Timezone); err != nil {
+			check("spaceapi.location.timezone", err)
+		}
+	}
+
+	if len(s.Contact) == 0 {
+		check("spaceapi.contact", fmt.Errorf("at least one contact is required"))
+	}
+}
+
+// decodeStrict decodes data into v, rejecting unknown fields. The
+// UnmarshalJSON methods pass an alias of their type, which drops the methods,
+// to avoid recursing into UnmarshalJSON.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validateHostPort(s string) error {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	if host == "" || port == "" {
+		return fmt.Errorf("%q must be host:port", s)
+	}
+	return nil
+}
+
+// validateURL accepts an empty string (feature disabled) unless required.
+func validateURL(s string, required bool) error {
+	if s == "" {
+		if required {
+			return fmt.Errorf("required")
+		}
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http:// or https:// URL", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", s)
+	}
+	return nil
+}
+
+// validateSecretURL is validateURL for a secret, without printing it.
+func validateSecretURL(s Secret, required bool) error {
+	if validateURL(s.Value(), required) != nil {
+		return fmt.Errorf("must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+// NeedsReconnect lists the settings that differ between old and new, and only
+// take effect when (re)connecting to IRC.
+func NeedsReconnect(old, new *Config) []string {
+	var changed []string
+	for _, s := range []struct {
+		field    string
+		old, new interface{}
+	}{
+		{"irc.server", old.IRC.Server, new.IRC.Server},
+		{"irc.channel", old.IRC.Channel, new.IRC.Channel},
+		{"irc.nick", old.IRC.Nick, new.IRC.Nick},
+		{"irc.password", old.IRC.Password.Value(), new.IRC.Password.Value()},
+		{"irc.auto_voice", old.IRC.AutoVoice, new.IRC.AutoVoice},
+	} {
+		if s.old != s.new {
+			changed = append(changed, s.field)
+		}
+	}
+	// Pins are set up when the status starts, after joining the channel.
+	if !reflect.DeepEqual(old.GPIO.Pins, new.GPIO.Pins) {
+		changed = append(changed, "gpio.pins")
+	}
+	return changed
+}
+
+// NeedsRestart lists the settings that differ between old and new, and only
+// take effect when foubot2 is restarted.
+func NeedsRestart(old, new *Config) []string {
+	var changed []string
+	if old.GPIO.Backend != new.GPIO.Backend {
+		changed = append(changed, "gpio.backend")
+	}
+	if old.GPIO.Chip != new.GPIO.Chip {
+		changed = append(changed, "gpio.chip")
+	}
+	if old.HTTP.Listen != new.HTTP.Listen {
+		changed = append(changed, "http.listen")
+	}
+	return changed
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(Default())
+}
+
+// Get returns the configuration in effect. Callers should call Get once per
+// operation and keep the result, rather than calling it for every setting.
+func Get() *Config {
+	return current.Load().(*Config)
+}
 
-// https://developers.mattermost.com/integrate/reference/personal-access-token/
-const MattermostToken = ""
+// Set replaces the configuration in effect.
+func Set(c *Config) {
+	current.Store(c)
+}
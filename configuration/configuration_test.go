@@ -0,0 +1,268 @@
+package configuration
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDefaults(t *testing.T) {
+	c, err := Parse([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if c.IRC.Channel != "#foulab" {
+		t.Errorf("IRC.Channel: got %q, want %q", c.IRC.Channel, "#foulab")
+	}
+	if time.Duration(c.Calendar.Interval) != 60*time.Minute {
+		t.Errorf("Calendar.Interval: got %s, want %s", c.Calendar.Interval, 60*time.Minute)
+	}
+}
+
+func TestParseOverrides(t *testing.T) {
+	c, err := Parse([]byte(`{
+		"irc": {"channel": "#test", "auto_voice": true},
+		"calendar": {"interval": "90m"}
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if c.IRC.Channel != "#test" {
+		t.Errorf("IRC.Channel: got %q, want %q", c.IRC.Channel, "#test")
+	}
+	if !c.IRC.AutoVoice {
+		t.Errorf("IRC.AutoVoice: got false, want true")
+	}
+	// Not mentioned, keeps default.
+	if c.IRC.Nick != "foubot" {
+		t.Errorf("IRC.Nick: got %q, want %q", c.IRC.Nick, "foubot")
+	}
+	if time.Duration(c.Calendar.Interval) != 90*time.Minute {
+		t.Errorf("Calendar.Interval: got %s, want %s", c.Calendar.Interval, 90*time.Minute)
+	}
+}
+
+func TestParsePinsReplaceDefaults(t *testing.T) {
+	c, err := Parse([]byte(`{"gpio": {"pins": {
+		"button": {"pin": 5, "direction": "input", "pull": "none", "active_low": true}
+	}}}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	want := Pins{"button": {Pin: 5, Direction: "input", Pull: "none", ActiveLow: true}}
+	if !reflect.DeepEqual(c.GPIO.Pins, want) {
+		t.Errorf("GPIO.Pins: got %+v, want %+v", c.GPIO.Pins, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{`{"irc": {"chanel": "#test"}}`, `unknown field "chanel"`},
+		{"{\n\"irc\": {\n\"channel\": 5}}", `line 3: irc.channel: cannot use number as string`},
+		{"{\n\"irc\": }", `line 2: invalid character`},
+		{`{"calendar": {"interval": "soon"}}`, `invalid duration`},
+		{`{} {}`, `unexpected data`},
+		{`{"irc": {"channel": "foulab"}}`, `irc.channel: "foulab" must start with # or &`},
+		{`{"irc": {"server": "irc.libera.chat"}}`, `irc.server:`},
+		{`{"calendar": {"interval": "10s"}}`, `calendar.interval: 10s is shorter than 1m`},
+		{`{"blinker": "blinker.lab"}`, `blinker.url: "blinker.lab" must be an http:// or https:// URL`},
+		{`{"blinker": {"url": "http://blinker.lab/", "closed": ["Dimmer 10", " "]}}`, `blinker.closed[1]: empty command`},
+		{`{"melody": {"url": "melody:6680"}}`, `melody.url:`},
+		{`{"melody": {"open": [{"method": "playback.play"}]}}`, `melody.open[0]: method "playback.play" must be like`},
+		{`{"melody": {"event_start": [{"method": "core.mixer.set_volume", "params": 30}]}}`, `melody.event_start[0]: params must be an object or an array`},
+		{`{"melody": {"closed": [{"method": "core.playback.stop", "params": null}]}}`, `melody.closed[0]: params must be an object or an array`},
+		{`{"blinker": {"check": "1s"}}`, `blinker.check: 1s must be 0 or at least 10s`},
+		{`{"blinker": {"colour": "red"}}`, `unknown field "colour"`},
+		{`{"mattermost": {"server": "https://chat.example"}}`, `mattermost.token: required`},
+		{`{"gpio": {"pins": {"led": {"pin": 1, "direction": "output"}}}}`, `gpio.pins: "button" is required`},
+		{`{"gpio": {"pins": {"button": {"pin": 1, "direction": "input", "pull": "sideways"}}}}`, `gpio.pins.button.pull: "sideways" is not one of`},
+		{`{"gpio": {"pins": {"button": {"pin": 1, "direction": "output"}}}}`, `gpio.pins.button.direction: must be "input"`},
+		{`{"gpio": {"pins": {"button": {"pin": 1, "direction": "input", "pull": "up"}, "led": {"pin": 1, "direction": "output"}}}}`, `gpio.pins.led.pin: 1 is already used by "button"`},
+		{`{"gpio": {"pins": {"button": {"pin": 1, "direction": "input", "pull": "up"}, "lever": {"pin": 2, "direction": "input", "pull": "up"}}}}`, `gpio.pins.lever: unknown input role`},
+		{`{"gpio": {"pins": {"button": {"pin": 1, "direction": "input", "pull": "up"}, "led": {"pin": 2, "direction": "output", "follows": "closed"}}}}`, `gpio.pins.led.follows: "closed" is not one of`},
+		{`{"http": {"listen": "8080"}}`, `http.listen:`},
+		{`{"stats": {"schedule": "Monday 9am"}}`, `schedule must be like "Mon 09:00"`},
+		{`{"override": {"trusted": ["alice"]}}`, `override.trusted[0]: "alice" must be like nick!user@host`},
+		{`{"nag": {"after": "11pm"}}`, `time of day must be like "23:30"`},
+		{`{"nag": {"repeat": "10s"}}`, `nag.repeat: 10s must be 0 or at least 1m`},
+		{`{"hooks": [{"command": ["/bin/true"], "on": ["opened"]}]}`, `hooks[0].on: "opened" is not one of`},
+		{`{"hooks": [{"on": ["open"]}]}`, `hooks[0].command: required`},
+		{`{"webhooks": [{"on": ["open"], "url": "https://example.org/"}]}`, `webhooks[0].name: required`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "example.org"}]}`, `webhooks[0].url: must be an http:// or https:// URL`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "https://example.org/", "method": "GET"}]}`, `webhooks[0].method: "GET" is not one of`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "https://example.org/", "body": "status={{.Status}}"}]}`, `webhooks[0].body: does not render to JSON`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "https://example.org/", "body": "{{.Status}} {{.Nope}}"}]}`, `webhooks[0].body:`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "https://example.org/", "timeout": "-1s"}]}`, `webhooks[0].timeout: must not be negative`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "https://example.org/", "retries": 3}]}`, `unknown field "retries"`},
+		{`{"sink_timeouts": {"lights": "1s"}}`, `sink_timeouts: "lights" is not one of`},
+		{`{"sink_timeouts": {"melody": "0s"}}`, `sink_timeouts.melody: must be positive`},
+		{`{"webhooks": [{"name": "a", "on": ["open"], "url": "https://example.org/"}, {"name": "a", "on": ["closed"], "url": "https://example.org/"}]}`, `webhooks[1].name: "a" is already used`},
+		{`{"sinks": ["irc", "lights"]}`, `sinks[1]: "lights" is not one of`},
+		{`{"sinks": ["irc", "irc"]}`, `sinks[1]: "irc" is listed twice`},
+		{`{"retry": {"min": "0s"}}`, `retry.min: 0s is shorter than 1s`},
+		{`{"retry": {"min": "1m", "max": "10s"}}`, `retry.max: 10s is shorter than retry.min`},
+		{`{"stats": {"period": "year"}}`, `stats.period: "year" is not one of`},
+		{`{"spaceapi": {"space": "Foulab"}}`, `spaceapi.location: lat and lon are required`},
+		{`{"spaceapi": {"space": "Foulab", "icon_open": "https://example.org/open.png"}}`, `spaceapi.icon_closed: required`},
+		{`{"spaceapi": {"space": "Foulab", "location": {"timezone": "Mars/Olympus"}}}`, `spaceapi.location.timezone:`},
+	} {
+		_, err := Parse([]byte(tc.config))
+		if err == nil {
+			t.Errorf("Parse(%q): got no error, want %q", tc.config, tc.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q): got error %q, want %q", tc.config, err, tc.want)
+		}
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	b, err := ioutil.ReadFile("../config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(b); err != nil {
+		t.Errorf("config.example.json: %s", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	c, err := Parse([]byte(`{"messages": {"starting_event": "Now: {{.Event}}!"}}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	got := c.Messages.StartingEvent.Render(map[string]string{"Event": "Soldering"})
+	if want := "Now: Soldering!"; got != want {
+		t.Errorf("Render: got %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{`{"messages": {"starting_event": "Now: {{.Event"}}`, `unclosed action`},
+		{`{"messages": {"announcement": "{{.Event}}"}}`, `messages.announcement:`},
+	} {
+		_, err := Parse([]byte(tc.config))
+		if err == nil {
+			t.Errorf("Parse(%q): got no error, want %q", tc.config, tc.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q): got error %q, want %q", tc.config, err, tc.want)
+		}
+	}
+}
+
+func TestBlinker(t *testing.T) {
+	c, err := Parse([]byte(`{"blinker": "http://plug.lab/"}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if c.Blinker.URL != "http://plug.lab/" || c.Blinker.Check != Duration(5*time.Minute) {
+		t.Errorf("Blinker from a string: got %+v", c.Blinker)
+	}
+
+	c, err = Parse([]byte(`{"blinker": {"url": "http://plug.lab/", "open": ["Dimmer 80"], "password": "s3cret"}}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if c.Blinker.URL != "http://plug.lab/" || c.Blinker.Check != Duration(5*time.Minute) || c.Blinker.Password.Value() != "s3cret" || !reflect.DeepEqual(c.Blinker.Open, []string{"Dimmer 80"}) {
+		t.Errorf("Blinker: got %+v", c.Blinker)
+	}
+}
+
+func TestWebhookDefaults(t *testing.T) {
+	c, err := Parse([]byte(`{"webhooks": [
+		{"name": "a", "on": ["open"], "url": "https://example.org/"},
+		{"name": "b", "on": ["open"], "url": "https://example.org/", "headers": {"Content-Type": "text/plain"}, "body": "{{.Status}}"}
+	]}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	a := c.Webhooks[0]
+	if a.Method != "POST" || a.Body.String() != DefaultWebhookBody.String() {
+		t.Errorf("Defaults: got method %q, body %q", a.Method, a.Body)
+	}
+	got := a.Body.Render(map[string]string{
+		"Event": "event_start", "Status": "OPEN", "Open": "true", "Since": "s", "Source": "button", "CalendarEvent": `"Hack" night`, "Time": "t",
+	})
+	want := `{"event": "event_start", "status": "OPEN", "open": true, "since": "s", "source": "button", "calendar_event": "\"Hack\" night", "time": "t"}`
+	if got != want {
+		t.Errorf("Render: got %s, want %s", got, want)
+	}
+	if b := c.Webhooks[1].Headers["Content-Type"].Value(); b != "text/plain" {
+		t.Errorf("Headers: got %q", b)
+	}
+}
+
+func TestNeedsReconnect(t *testing.T) {
+	old := Default()
+	new := Default()
+	new.Calendar.URL = "https://example.com/calendar.ics"
+	if got := NeedsReconnect(old, new); len(got) != 0 {
+		t.Errorf("NeedsReconnect: got %q, want none", got)
+	}
+	new.IRC.Channel = "#test"
+	got := NeedsReconnect(old, new)
+	if len(got) != 1 || got[0] != "irc.channel" {
+		t.Errorf("NeedsReconnect: got %q, want %q", got, []string{"irc.channel"})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	c, err := Parse([]byte(`{"stats": {"schedule": "Mon 09:00"}}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	s := c.Stats.Schedule
+	if got := s.String(); got != "Mon 09:00" {
+		t.Errorf("String: got %q, want %q", got, "Mon 09:00")
+	}
+	for _, tc := range []struct {
+		now, want time.Time
+	}{
+		// Wednesday.
+		{time.Date(2025, 1, 8, 12, 0, 0, 0, time.UTC), time.Date(2025, 1, 13, 9, 0, 0, 0, time.UTC)},
+		// Monday, before and at 09:00.
+		{time.Date(2025, 1, 13, 8, 59, 0, 0, time.UTC), time.Date(2025, 1, 13, 9, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 1, 13, 9, 0, 0, 0, time.UTC), time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC)},
+	} {
+		if got := s.Next(tc.now); !got.Equal(tc.want) {
+			t.Errorf("Next(%s): got %s, want %s", tc.now, got, tc.want)
+		}
+	}
+
+	if !Default().Stats.Schedule.IsZero() {
+		t.Errorf("Default schedule: got %s, want none", Default().Stats.Schedule)
+	}
+}
+
+func TestOverrideAllows(t *testing.T) {
+	o := Override{Trusted: []string{"*!*@user/alice", "bob!~bob@192.0.2.?"}}
+	for _, tc := range []struct {
+		source string
+		want   bool
+	}{
+		{"alice!~alice@user/alice", true},
+		{"Alice_!x@USER/Alice", true},
+		{"mallory!~m@user/alice/bot", false},
+		{"bob!~bob@192.0.2.7", true},
+		{"bob!~bob@192.0.2.77", false},
+		{"bobby!~bob@192.0.2.7", false},
+		{"", false},
+	} {
+		if got := o.Allows(tc.source); got != tc.want {
+			t.Errorf("Allows(%q): got %v, want %v", tc.source, got, tc.want)
+		}
+	}
+	if (Override{}).Allows("alice!~alice@user/alice") {
+		t.Errorf("Allows with no trusted masks: got true, want false")
+	}
+}
@@ -0,0 +1,147 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a time of the week, written "Mon 09:00" (local time) in the
+// configuration file. The zero Schedule, written "", is never.
+type Schedule struct {
+	Weekday time.Weekday
+	Hour    int
+	Minute  int
+	set     bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday, "Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday,
+	"Thu": time.Thursday, "Fri": time.Friday, "Sat": time.Saturday,
+}
+
+func ParseSchedule(s string) (Schedule, error) {
+	if s == "" {
+		return Schedule{}, nil
+	}
+	bad := fmt.Errorf("schedule must be like \"Mon 09:00\", got %q", s)
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Schedule{}, bad
+	}
+	wd, ok := weekdays[fields[0]]
+	if !ok {
+		return Schedule{}, bad
+	}
+	t, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return Schedule{}, bad
+	}
+	return Schedule{Weekday: wd, Hour: t.Hour(), Minute: t.Minute(), set: true}, nil
+}
+
+func (s *Schedule) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("schedule must be a string like \"Mon 09:00\", got %s", b)
+	}
+	v, err := ParseSchedule(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (s Schedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Schedule) String() string {
+	if !s.set {
+		return ""
+	}
+	return fmt.Sprintf("%s %02d:%02d", s.Weekday.String()[:3], s.Hour, s.Minute)
+}
+
+// IsZero reports whether s is never.
+func (s Schedule) IsZero() bool {
+	return !s.set
+}
+
+// Next returns the first time after now on the schedule, in now's location.
+func (s Schedule) Next(now time.Time) time.Time {
+	if !s.set {
+		return time.Time{}
+	}
+	days := (int(s.Weekday) - int(now.Weekday()) + 7) % 7
+	y, m, d := now.Date()
+	next := time.Date(y, m, d+days, s.Hour, s.Minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(y, m, d+days+7, s.Hour, s.Minute, 0, 0, now.Location())
+	}
+	return next
+}
+
+// TimeOfDay is written "23:30" (local time) in the configuration file. The
+// zero TimeOfDay, written "", is never.
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+	set    bool
+}
+
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	if s == "" {
+		return TimeOfDay{}, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return TimeOfDay{}, fmt.Errorf("time of day must be like \"23:30\", got %q", s)
+	}
+	return TimeOfDay{Hour: t.Hour(), Minute: t.Minute(), set: true}, nil
+}
+
+func (t *TimeOfDay) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("time of day must be a string like \"23:30\", got %s", b)
+	}
+	v, err := ParseTimeOfDay(str)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+func (t TimeOfDay) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t TimeOfDay) String() string {
+	if !t.set {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+// IsZero reports whether t is never.
+func (t TimeOfDay) IsZero() bool {
+	return !t.set
+}
+
+// Next returns the first time at or after from at this time of day, in
+// from's location.
+func (t TimeOfDay) Next(from time.Time) time.Time {
+	if !t.set {
+		return time.Time{}
+	}
+	y, m, d := from.Date()
+	next := time.Date(y, m, d, t.Hour, t.Minute, 0, 0, from.Location())
+	if next.Before(from) {
+		next = time.Date(y, m, d+1, t.Hour, t.Minute, 0, 0, from.Location())
+	}
+	return next
+}
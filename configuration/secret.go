@@ -0,0 +1,167 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Secret is a setting which should not have to be written in the
+// configuration file itself. It is either a plain string, or an object naming
+// exactly one place to read the value from:
+//
+//	"password": {"env": "FOUBOT2_IRC_PASSWORD"}
+//	"password": {"file": "/etc/foubot2/irc_password"}
+//	"password": {"credential": "irc_password"}
+//
+// "credential" reads the file of that name in $CREDENTIALS_DIRECTORY, as set up
+// by systemd ImportCredential= or LoadCredential=.
+type Secret struct {
+	Env        string `json:"env,omitempty"`
+	File       string `json:"file,omitempty"`
+	Credential string `json:"credential,omitempty"`
+
+	// Secrets not mentioned in the configuration file look in their default
+	// environment variable and credential, but it's not an error if neither
+	// is there.
+	optional bool
+
+	value    string
+	resolved bool
+}
+
+// defaultSecret is looked up in the FOUBOT2_<NAME> environment variable, then
+// in the credential <name>.
+func defaultSecret(name string) Secret {
+	return Secret{
+		Env:        "FOUBOT2_" + strings.ToUpper(name),
+		Credential: name,
+		optional:   true,
+	}
+}
+
+// Value returns the secret. Only valid on a configuration returned by Load or
+// Parse.
+func (s Secret) Value() string {
+	return s.value
+}
+
+func (s *Secret) UnmarshalJSON(b []byte) error {
+	var literal string
+	if err := json.Unmarshal(b, &literal); err == nil {
+		*s = Secret{value: literal, resolved: true}
+		return nil
+	}
+
+	type alias Secret
+	var ref alias
+	if err := decodeStrict(b, &ref); err != nil {
+		return fmt.Errorf("secret must be a string, or an object with one of \"env\", \"file\" or \"credential\": %s", err)
+	}
+	n := 0
+	for _, v := range []string{ref.Env, ref.File, ref.Credential} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("secret must have exactly one of \"env\", \"file\" or \"credential\", got %s", b)
+	}
+	*s = Secret(ref)
+	return nil
+}
+
+func (s *Secret) resolve() error {
+	if s.resolved {
+		return nil
+	}
+	v, err := s.lookup()
+	if err != nil {
+		return err
+	}
+	s.value = v
+	s.resolved = true
+	return nil
+}
+
+func (s *Secret) lookup() (string, error) {
+	if s.Env != "" {
+		if v, ok := os.LookupEnv(s.Env); ok {
+			return v, nil
+		}
+		if !s.optional {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+	}
+
+	if s.Credential != "" {
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir != "" {
+			b, err := ioutil.ReadFile(filepath.Join(dir, s.Credential))
+			if err == nil {
+				return trimNewline(string(b)), nil
+			}
+			if !s.optional || !os.IsNotExist(err) {
+				return "", fmt.Errorf("credential %q: %s", s.Credential, err)
+			}
+		} else if !s.optional {
+			return "", fmt.Errorf("credential %q: $CREDENTIALS_DIRECTORY is not set (missing ImportCredential= or LoadCredential= in the systemd unit?)", s.Credential)
+		}
+	}
+
+	if s.File != "" {
+		b, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", err
+		}
+		return trimNewline(string(b)), nil
+	}
+
+	return "", nil
+}
+
+// Secret files are usually written by an editor or echo, which add a final
+// newline that is not part of the secret.
+func trimNewline(s string) string {
+	return strings.TrimRight(s, "\r\n")
+}
+
+func (c *Config) resolveSecrets() error {
+	var problems []string
+	for _, s := range []struct {
+		field  string
+		secret *Secret
+	}{
+		{"irc.password", &c.IRC.Password},
+		{"mattermost.token", &c.Mattermost.Token},
+		{"status_endpoint", &c.StatusEndPoint},
+		{"blinker.password", &c.Blinker.Password},
+	} {
+		if err := s.secret.resolve(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", s.field, err))
+		}
+	}
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+		field := fmt.Sprintf("webhooks[%d]", i)
+		if err := w.URL.resolve(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.url: %s", field, err))
+		}
+		if err := w.Secret.resolve(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.secret: %s", field, err))
+		}
+		for name, h := range w.Headers {
+			if err := h.resolve(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.headers.%s: %s", field, name, err))
+			}
+			w.Headers[name] = h
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("reading secrets:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "foubot2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "irc_password"), []byte("from-credential\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CREDENTIALS_DIRECTORY", dir)
+	defer os.Unsetenv("CREDENTIALS_DIRECTORY")
+	os.Setenv("TEST_STATUS_ENDPOINT", "https://example.com/secret/")
+	defer os.Unsetenv("TEST_STATUS_ENDPOINT")
+
+	c, err := Parse([]byte(`{
+		"mattermost": {
+			"server": "https://chat.example",
+			"channel_id": "abc",
+			"token": {"file": "` + filepath.Join(dir, "token") + `"}
+		},
+		"status_endpoint": {"env": "TEST_STATUS_ENDPOINT"}
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	for _, tc := range []struct {
+		field string
+		got   Secret
+		want  string
+	}{
+		// Not in the file, found in the default credential.
+		{"irc.password", c.IRC.Password, "from-credential"},
+		{"mattermost.token", c.Mattermost.Token, "from-file"},
+		{"status_endpoint", c.StatusEndPoint, "https://example.com/secret/"},
+	} {
+		if tc.got.Value() != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.field, tc.got.Value(), tc.want)
+		}
+	}
+}
+
+func TestSecretLiteral(t *testing.T) {
+	c, err := Parse([]byte(`{"irc": {"password": "hunter2"}}`))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if c.IRC.Password.Value() != "hunter2" {
+		t.Errorf("irc.password: got %q, want %q", c.IRC.Password.Value(), "hunter2")
+	}
+}
+
+func TestSecretErrors(t *testing.T) {
+	os.Unsetenv("CREDENTIALS_DIRECTORY")
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{`{"irc": {"password": {"env": "TEST_UNSET_VARIABLE"}}}`, `irc.password: environment variable TEST_UNSET_VARIABLE is not set`},
+		{`{"irc": {"password": {"credential": "irc_password"}}}`, `irc.password: credential "irc_password": $CREDENTIALS_DIRECTORY is not set`},
+		{`{"irc": {"password": {"file": "/nonexistent/foubot2"}}}`, `irc.password: open /nonexistent/foubot2`},
+		{`{"irc": {"password": {}}}`, `exactly one of`},
+		{`{"irc": {"password": {"env": "A", "file": "/b"}}}`, `exactly one of`},
+		{`{"irc": {"password": {"environment": "A"}}}`, `unknown field "environment"`},
+		{`{"status_endpoint": "foulab.org/secret"}`, `status_endpoint: must be an http:// or https:// URL`},
+	} {
+		_, err := Parse([]byte(tc.config))
+		if err == nil {
+			t.Errorf("Parse(%q): got no error, want %q", tc.config, tc.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q): got error %q, want %q", tc.config, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// Template is a message with placeholders, eg. "Starting event: {{.Event}}",
+// see https://pkg.go.dev/text/template.
+type Template struct {
+	text string
+	t    *template.Template
+}
+
+var funcs = template.FuncMap{
+	// Quotes a placeholder as a JSON string, see Webhook.
+	"json": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+}
+
+func mustTemplate(text string) Template {
+	t, err := parseTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func parseTemplate(text string) (Template, error) {
+	t, err := template.New("").Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return Template{}, err
+	}
+	return Template{text, t}, nil
+}
+
+func (t *Template) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err != nil {
+		return fmt.Errorf("template must be a string, got %s", b)
+	}
+	v, err := parseTemplate(text)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+func (t Template) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// String returns the template text.
+func (t Template) String() string {
+	return t.text
+}
+
+// Render fills in the placeholders from data. Templates are checked when the
+// configuration is loaded, so errors here are unexpected; they are logged, and
+// the message is sent with the placeholders unfilled.
+func (t Template) Render(data map[string]string) string {
+	s, err := t.execute(data)
+	if err != nil {
+		log.Printf("Render template %q: %s", t, err)
+		return t.String()
+	}
+	return s
+}
+
+func (t Template) execute(data map[string]string) (string, error) {
+	var b strings.Builder
+	if err := t.t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validate renders the template with the given placeholders, to catch
+// references to placeholders that don't exist.
+func (t Template) validate(placeholders ...string) error {
+	data := make(map[string]string)
+	for _, p := range placeholders {
+		data[p] = p
+	}
+	if _, err := t.execute(data); err != nil {
+		return fmt.Errorf("%s (available: %s)", err, strings.Join(placeholders, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// Package httperr describes HTTP replies with an unexpected status, so that
+// callers can tell which failures are worth retrying.
+package httperr
+
+import "net/http"
+
+// StatusError is a reply with an unexpected status.
+type StatusError struct {
+	// Zero if there was no reply.
+	Code int
+	Text string
+}
+
+func (e *StatusError) Error() string { return e.Text }
+
+// Unexpected returns the error for the status of resp, eg. "unexpected status
+// 503 Service Unavailable".
+func Unexpected(resp *http.Response) *StatusError {
+	return &StatusError{Code: resp.StatusCode, Text: "unexpected status " + resp.Status}
+}
@@ -1,25 +1,80 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"crypto/tls"
 	"foubot2/configuration"
+	"foubot2/metrics"
 	"foubot2/status"
+	"foubot2/web"
 	irc "github.com/thoj/go-ircevent"
 	"net"
+	"net/http"
 )
 
-const botChannel = configuration.BotChannel
-const botNick = configuration.BotNick
-const botPswd = configuration.BotPswd
-const servertls = configuration.ServerTLS
+// The status of the current connection, if any. Guarded by mu.
+var current struct {
+	mu     sync.Mutex
+	button *ledsign.SWITCHSTATE
+	irc    web.IRCState
+}
+
+var (
+	ircConnected = metrics.NewGauge("foubot2_irc_connected",
+		"Whether foubot2 is connected to IRC (1), registered or in the channel.")
+	ircReconnects = metrics.NewCounter("foubot2_irc_reconnects_total",
+		"Connections to IRC after the first one.")
+)
+
+func setIRCState(state string) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.irc = web.IRCState{State: state, Since: time.Now()}
+	if state == "connected" || state == "joined" {
+		ircConnected.Set(1)
+	} else {
+		ircConnected.Set(0)
+	}
+}
+
+// bot reports on the current connection to the HTTP server.
+type bot struct{}
+
+func (bot) Lab() web.Lab {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if current.button == nil {
+		return nil
+	}
+	return current.button
+}
+
+func (bot) IRC() web.IRCState {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	return current.irc
+}
+
+// Opened once at startup, shared by all connections.
+var gpio ledsign.GPIO
+
+var configPath = flag.String("config", configuration.DefaultPath, "path to the JSON configuration file; empty to use the built-in defaults")
 
 func handleMessages(event *irc.Event, irc *irc.Connection) {
+	cfg := configuration.Get()
+	botChannel := cfg.IRC.Channel
+
 	target := event.Nick
 	prefix := ""
 	if event.Arguments[0] == botChannel {
@@ -29,7 +84,7 @@ func handleMessages(event *irc.Event, irc *irc.Connection) {
 
 	command := strings.Split(event.Arguments[1], " ")[0]
 
-	if command == "!vox" && configuration.BotAutoVoice {
+	if command == "!vox" && cfg.IRC.AutoVoice {
 		irc.Mode(botChannel, "+v", event.Nick)
 
 		irc.Privmsg(target, fmt.Sprintf("%sAlrity then!", prefix))
@@ -38,17 +93,68 @@ func handleMessages(event *irc.Event, irc *irc.Connection) {
 	}
 
 	if command == "!status" {
-		status := ledsign.GetSwitchStatus()
+		current.mu.Lock()
+		button := current.button
+		current.mu.Unlock()
+		if button == nil {
+			irc.Privmsg(target, fmt.Sprintf("%sStill starting up, try again in a moment.", prefix))
+			return
+		}
+
+		status := button.GetSwitchStatus()
+		now := time.Now()
+		since := button.GetSince()
+		data := map[string]string{
+			"Since":    ledsign.FormatSince(since, now),
+			"Duration": ledsign.FormatDuration(now.Sub(since)),
+		}
+		var reply string
 		if status {
-			irc.Privmsg(target, fmt.Sprintf("%sThe lab is currently OPEN.", prefix))
+			reply = cfg.Messages.Open.Render(data)
 		} else {
-			irc.Privmsg(target, fmt.Sprintf("%sSadly, the lab is currently CLOSED.", prefix))
+			reply = cfg.Messages.Closed.Render(data)
+		}
+		if o := button.GetOverride(); o != nil {
+			reply += fmt.Sprintf(" (set by hand by %s", o.By)
+			if !o.Until.IsZero() {
+				reply += ", until " + ledsign.FormatSince(o.Until, now)
+			}
+			reply += ")"
 		}
+		irc.Privmsg(target, prefix+reply)
+
+		return
+	}
 
+	if command == "!history" {
+		handleHistory(cfg, event, irc, target, prefix)
 		return
 	}
 
-	match, _ := regexp.MatchString(botNick, event.Arguments[1])
+	if command == "!open" || command == "!close" || command == "!auto" {
+		handleOverride(cfg, command, event, irc, target, prefix)
+		return
+	}
+
+	if command == "!ack" {
+		current.mu.Lock()
+		button := current.button
+		current.mu.Unlock()
+		if button != nil && button.AckNag() {
+			log.Printf("Reminder acknowledged by %s", event.Source)
+			irc.Privmsg(target, fmt.Sprintf("%sOK, no more reminders until the lab closes.", prefix))
+		} else {
+			irc.Privmsg(target, fmt.Sprintf("%sNothing to acknowledge.", prefix))
+		}
+		return
+	}
+
+	if command == "!stats" {
+		handleStats(cfg, event, irc, target, prefix)
+		return
+	}
+
+	match, _ := regexp.MatchString(cfg.IRC.Nick, event.Arguments[1])
 	if match {
 		irc.Privmsg(target, fmt.Sprintf("%su wot m8?", prefix))
 		return
@@ -60,60 +166,186 @@ func handleMessages(event *irc.Event, irc *irc.Connection) {
 	}
 }
 
+// handleHistory answers "!history [n]" with the last n changes of lab status.
+func handleHistory(cfg *configuration.Config, event *irc.Event, irc *irc.Connection, target, prefix string) {
+	const maxHistory = 10
+	n := 5
+	if args := strings.Fields(event.Arguments[1]); len(args) > 1 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			irc.Privmsg(target, fmt.Sprintf("%sUsage: !history [n]", prefix))
+			return
+		}
+		if n > maxHistory {
+			n = maxHistory
+		}
+	}
+
+	if cfg.HistoryFile == "" {
+		irc.Privmsg(target, fmt.Sprintf("%sHistory is not enabled.", prefix))
+		return
+	}
+	history, err := ledsign.ReadHistory(cfg.HistoryFile)
+	if err != nil {
+		log.Printf("Read history: %s", err)
+		irc.Privmsg(target, fmt.Sprintf("%sCan't read the history, sorry.", prefix))
+		return
+	}
+	if len(history) == 0 {
+		irc.Privmsg(target, fmt.Sprintf("%sNo history yet.", prefix))
+		return
+	}
+	for _, line := range ledsign.FormatHistory(history, n, time.Now()) {
+		irc.Privmsg(target, prefix+line)
+	}
+}
+
+// handleOverride answers "!open [duration]", "!close [duration]" and "!auto",
+// which set the lab status by hand instead of the button, for trusted users.
+func handleOverride(cfg *configuration.Config, command string, event *irc.Event, irc *irc.Connection, target, prefix string) {
+	if !cfg.Override.Allows(event.Source) {
+		log.Printf("%s from %s: not trusted", command, event.Source)
+		irc.Privmsg(target, fmt.Sprintf("%sSorry, you're not allowed to do that.", prefix))
+		return
+	}
+
+	current.mu.Lock()
+	button := current.button
+	current.mu.Unlock()
+	if button == nil {
+		irc.Privmsg(target, fmt.Sprintf("%sStill starting up, try again in a moment.", prefix))
+		return
+	}
+
+	if command == "!auto" {
+		log.Printf("!auto from %s", event.Source)
+		button.SetOverride(nil)
+		irc.Privmsg(target, fmt.Sprintf("%sOK, the button decides again.", prefix))
+		return
+	}
+
+	now := time.Now()
+	o := &ledsign.Override{Open: command == "!open", By: event.Nick}
+	if args := strings.Fields(event.Arguments[1]); len(args) > 1 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			irc.Privmsg(target, fmt.Sprintf("%sUsage: %s [duration, eg. 3h]", prefix, command))
+			return
+		}
+		o.Until = now.Add(d)
+	}
+	log.Printf("%s from %s: %+v", command, event.Source, *o)
+	button.SetOverride(o)
+
+	status := "CLOSED"
+	if o.Open {
+		status = "OPEN"
+	}
+	until := ""
+	if !o.Until.IsZero() {
+		until = " until " + ledsign.FormatSince(o.Until, now) + ", or"
+	}
+	irc.Privmsg(target, fmt.Sprintf("%sOK, the lab is %s%s until !auto or the button is used.", prefix, status, until))
+}
+
+// handleStats answers "!stats [week|month]" with how long the lab was open in
+// the last 7 or 30 days.
+func handleStats(cfg *configuration.Config, event *irc.Event, irc *irc.Connection, target, prefix string) {
+	period := "week"
+	if args := strings.Fields(event.Arguments[1]); len(args) > 1 {
+		period = args[1]
+	}
+	if period != "week" && period != "month" {
+		irc.Privmsg(target, fmt.Sprintf("%sUsage: !stats [week|month]", prefix))
+		return
+	}
+
+	if cfg.HistoryFile == "" {
+		irc.Privmsg(target, fmt.Sprintf("%sHistory is not enabled.", prefix))
+		return
+	}
+	history, err := ledsign.ReadHistory(cfg.HistoryFile)
+	if err != nil {
+		log.Printf("Read history: %s", err)
+		irc.Privmsg(target, fmt.Sprintf("%sCan't read the history, sorry.", prefix))
+		return
+	}
+	from, to := ledsign.StatsPeriod(period, time.Now())
+	for _, line := range ledsign.FormatStats(ledsign.ComputeStats(history, from, to)) {
+		irc.Privmsg(target, prefix+line)
+	}
+}
+
 func handleJoin(event *irc.Event, irc *irc.Connection) {
 	go func() {
 		time.Sleep(time.Minute * 5)
-		irc.Mode(botChannel, "+v", event.Nick)
+		irc.Mode(configuration.Get().IRC.Channel, "+v", event.Nick)
 	}()
 }
 
 func handleNick(event *irc.Event, irc *irc.Connection) {
 	go func() {
 		time.Sleep(time.Minute * 5)
-		irc.Mode(botChannel, "+v", event.Nick)
+		irc.Mode(configuration.Get().IRC.Channel, "+v", event.Nick)
 	}()
 }
 
 func handlePart(event *irc.Event, irc *irc.Connection) {
 	go func() {
 		time.Sleep(time.Minute * 5)
-		irc.Mode(botChannel, "+v", event.Nick)
+		irc.Mode(configuration.Get().IRC.Channel, "+v", event.Nick)
 	}()
 }
 
 func connectOnce() {
+	cfg := configuration.Get()
+	botChannel := cfg.IRC.Channel
+	botNick := cfg.IRC.Nick
+
 	irccon := irc.IRC(botNick, "foubot2")
 	irccon.VerboseCallbackHandler = false
 	irccon.Debug = false
 	irccon.UseTLS = true
-	host, _, err := net.SplitHostPort(servertls)
+	host, _, err := net.SplitHostPort(cfg.IRC.Server)
 	if err != nil {
 		log.Panicf("parse IRC server: %s\n", err)
 	}
 	irccon.TLSConfig = &tls.Config{ServerName: host}
-	if botPswd != "" {
+	if botPswd := cfg.IRC.Password.Value(); botPswd != "" {
 		irccon.UseSASL = true
 		irccon.SASLLogin = botNick
 		irccon.SASLPassword = botPswd
 	}
 
 	var button *ledsign.SWITCHSTATE
-	defer func() {
-		if button != nil {
-			button.CloseSwitchStatus()
-		}
-	}()
 
 	irccon.AddCallback("001", func(e *irc.Event) {
+		setIRCState("connected")
 		log.Printf("Got welcome, joining %s", botChannel)
 		irccon.Join(botChannel)
 	})
 	irccon.AddCallback("332", func(e *irc.Event) {
 		log.Printf("Got topic, starting status goroutine")
-		button = ledsign.NewSwitchStatus(e.Arguments[2], irccon)
+		b, err := ledsign.NewSwitchStatus(e.Arguments[2], irccon, gpio)
+		if err != nil {
+			log.Printf("Start status: %s", err)
+			// Disconnect, to try again on the next connection.
+			select {
+			case irccon.ErrorChan() <- fmt.Errorf("start status: %s", err):
+			default:
+			}
+			return
+		}
+		button = b
+
+		current.mu.Lock()
+		current.button = button
+		current.mu.Unlock()
+		setIRCState("joined")
 	})
 	irccon.AddCallback("PRIVMSG", func(e *irc.Event) { handleMessages(e, irccon) })
-	if configuration.BotAutoVoice {
+	if cfg.IRC.AutoVoice {
 		irccon.AddCallback("JOIN", func(e *irc.Event) { handleJoin(e, irccon) })
 		irccon.AddCallback("NICK", func(e *irc.Event) { handleNick(e, irccon) })
 		irccon.AddCallback("PART", func(e *irc.Event) { handlePart(e, irccon) })
@@ -125,7 +357,8 @@ func connectOnce() {
 	// This specific code pattern observed to:
 	// 1) reconnect reliably
 	// 2) not leak goroutines
-	err = irccon.Connect(servertls)
+	setIRCState("connecting")
+	err = irccon.Connect(cfg.IRC.Server)
 	defer func() {
 		// Workaround for https://github.com/thoj/go-ircevent/issues/112#issuecomment-2569796268:
 		// If Connect() fails early (eg. from Dial), irccon.Error is not yet
@@ -138,8 +371,20 @@ func connectOnce() {
 		irccon.Unlock()
 
 		irccon.Disconnect()
+		setIRCState("disconnected")
 		fmt.Printf("IRC disconnected\n")
 	}()
+	// Runs before the Disconnect above: the status and its sink workers may
+	// still be sending to IRC.
+	defer func() {
+		if button != nil {
+			current.mu.Lock()
+			current.button = nil
+			current.mu.Unlock()
+
+			button.CloseSwitchStatus()
+		}
+	}()
 	if err != nil {
 		fmt.Printf("Connect error: %s\n", err)
 		return
@@ -149,9 +394,69 @@ func connectOnce() {
 	fmt.Printf("Error, disconnected: %s\n", err)
 }
 
+// reloadOnSIGHUP reads the configuration file again on SIGHUP. Most settings
+// apply right away; IRC settings wait for the next reconnect.
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := configuration.Load(*configPath)
+		if err != nil {
+			log.Printf("Config reload failed, keeping the current configuration: %s", err)
+			continue
+		}
+
+		old := configuration.Get()
+		configuration.Set(cfg)
+
+		current.mu.Lock()
+		if current.button != nil {
+			current.button.Reload(cfg)
+		}
+		current.mu.Unlock()
+
+		log.Printf("Config reloaded")
+		if changed := configuration.NeedsReconnect(old, cfg); len(changed) > 0 {
+			log.Printf("Config: takes effect after reconnecting: %s", strings.Join(changed, ", "))
+		}
+		if changed := configuration.NeedsRestart(old, cfg); len(changed) > 0 {
+			log.Printf("Config: takes effect after restarting: %s", strings.Join(changed, ", "))
+		}
+	}
+}
+
 func main() {
+	flag.Parse()
+
+	cfg, err := configuration.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Config: %s", err)
+	}
+	configuration.Set(cfg)
+
+	gpio, err = ledsign.OpenGPIO(cfg.GPIO.Backend, cfg.GPIO.Chip)
+	if err != nil {
+		log.Fatalf("GPIO: %s", err)
+	}
+
+	setIRCState("disconnected")
+	if cfg.HTTP.Listen != "" {
+		// Listen now, so that a port in use fails at startup.
+		l, err := net.Listen("tcp", cfg.HTTP.Listen)
+		if err != nil {
+			log.Fatalf("HTTP: %s", err)
+		}
+		log.Printf("HTTP listening on %s", l.Addr())
+		go func() {
+			log.Fatalf("HTTP: %s", http.Serve(l, web.NewServer(bot{})))
+		}()
+	}
+
+	go reloadOnSIGHUP()
+
 	for {
 		connectOnce()
 		time.Sleep(60 * time.Second)
+		ircReconnects.Inc()
 	}
 }
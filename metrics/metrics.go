@@ -0,0 +1,203 @@
+// Package metrics keeps counters, gauges and histograms, and serves them in
+// the Prometheus text format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/).
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// family is a metric and all its label values.
+type family struct {
+	name, help, kind string
+	labels           []string
+	// Histograms: upper bounds, increasing.
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Histograms: counts per bucket (not cumulative), and their sum.
+	counts []uint64
+	sum    float64
+}
+
+func register(name, help, kind string, labels []string) *family {
+	f := &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, other := range registry.families {
+		if other.name == name {
+			panic("metrics: " + name + " registered twice")
+		}
+	}
+	registry.families = append(registry.families, f)
+	return f
+}
+
+// get returns the series for labelValues, creating it if needed. f.mu must be
+// held.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s: got %d label values, want %d", f.name, len(labelValues), len(f.labels)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value which only goes up, eg. a number of requests.
+type Counter struct{ f *family }
+
+// NewCounter registers a counter. Its name should end in "_total".
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(name, help, "counter", labels)}
+}
+
+// Inc adds one to the series for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labelValues).value++
+}
+
+// Gauge is a value which goes up and down, eg. whether the lab is open.
+type Gauge struct{ f *family }
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(name, help, "gauge", labels)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(labelValues).value = v
+}
+
+// Histogram counts observations (eg. request durations) in buckets.
+type Histogram struct{ f *family }
+
+// DurationBuckets suit network requests, in seconds.
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	f := register(name, help, "histogram", labels)
+	f.buckets = buckets
+	return &Histogram{f}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	i := sort.SearchFloat64s(h.f.buckets, v)
+	s.counts[i]++
+	s.sum += v
+}
+
+// Write writes all metrics in the Prometheus text format.
+func Write(w io.Writer) error {
+	registry.mu.Lock()
+	families := append([]*family(nil), registry.families...)
+	registry.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	var keys []string
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, f.labelString(s.labelValues, "", 0), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, le := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "le", le), cumulative)
+		}
+		cumulative += s.counts[len(f.buckets)]
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "le", math.Inf(1)), cumulative)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, f.labelString(s.labelValues, "", 0), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, f.labelString(s.labelValues, "", 0), cumulative)
+	}
+}
+
+// labelString formats the labels of a series, and the "le" label of a
+// histogram bucket if extra is set.
+func (f *family) labelString(values []string, extra string, le float64) string {
+	var pairs []string
+	for i, l := range f.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", l, escapeLabel(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra, formatFloat(le)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Handler serves all metrics, eg. at /metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
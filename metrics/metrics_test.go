@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.\nBy sink.", "sink")
+	g := NewGauge("test_open", "Whether it's open.")
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "sink")
+
+	c.Inc(`we"b`)
+	c.Inc(`we"b`)
+	c.Inc("blinker")
+	g.Set(1)
+	h.Observe(0.05, "web")
+	h.Observe(0.5, "web")
+	h.Observe(3, "web")
+
+	var b strings.Builder
+	if err := Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{sink="web",le="0.1"} 1
+test_duration_seconds_bucket{sink="web",le="1"} 2
+test_duration_seconds_bucket{sink="web",le="+Inf"} 3
+test_duration_seconds_sum{sink="web"} 3.55
+test_duration_seconds_count{sink="web"} 3
+# HELP test_open Whether it's open.
+# TYPE test_open gauge
+test_open 1
+# HELP test_requests_total Requests.\nBy sink.
+# TYPE test_requests_total counter
+test_requests_total{sink="blinker"} 1
+test_requests_total{sink="we\"b"} 2
+`
+	if got := b.String(); got != want {
+		t.Errorf("Write: got\n%s\nwant\n%s", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type: got %q, want text/plain; version=0.0.4", got)
+	}
+	if w.Body.String() != want {
+		t.Errorf("Handler: got\n%s\nwant\n%s", w.Body.String(), want)
+	}
+}
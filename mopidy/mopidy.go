@@ -0,0 +1,92 @@
+// Package mopidy calls the JSON-RPC API of a Mopidy music server
+// (https://docs.mopidy.com/en/latest/api/http/).
+package mopidy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"foubot2/httperr"
+)
+
+// Client talks to one server.
+type Client struct {
+	// The JSON-RPC endpoint, eg. "http://melody/mopidy/rpc".
+	URL        string
+	HTTPClient *http.Client
+
+	lastID int64
+}
+
+// Error is an error returned by the server, eg. for an unknown method or a
+// bad parameter.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	// Mopidy puts the Python exception in data.
+	var data struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(e.Data, &data) == nil && data.Message != "" {
+		return fmt.Sprintf("%s (%d): %s: %s", e.Message, e.Code, data.Type, data.Message)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Call calls method, eg. "core.mixer.set_volume", with params, eg.
+// {"volume": 30} (nil for none), and returns the result.
+func (c *Client) Call(method string, params json.RawMessage) (json.RawMessage, error) {
+	id := atomic.AddInt64(&c.lastID, 1)
+	b, err := json.Marshal(request{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", method, err)
+	}
+
+	resp, err := c.HTTPClient.Post(c.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	b, err = ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %w", method, httperr.Unexpected(resp))
+	}
+
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("%s: reply is not JSON-RPC: %q", method, b)
+	}
+	if r.Error != nil {
+		return nil, fmt.Errorf("%s: %w", method, r.Error)
+	}
+	if r.ID != id {
+		return nil, fmt.Errorf("%s: reply to request %d, want %d", method, r.ID, id)
+	}
+	return r.Result, nil
+}
@@ -0,0 +1,55 @@
+package mopidy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	var got []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JSONRPC != "2.0" {
+			t.Errorf("Request: %+v, %v", req, err)
+		}
+		got = append(got, req.Method+" "+string(req.Params))
+		switch req.Method {
+		case "core.mixer.set_volume":
+			fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": %d, "result": true}`, req.ID)
+		case "core.tracklist.add":
+			fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": %d, "error": {"code": -32603, "message": "Application error",
+				"data": {"type": "ValueError", "message": "One of \"tracks\", \"uri\" or \"uris\" must be set", "traceback": "..."}}}`, req.ID)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": %d, "error": {"code": -32601, "message": "Method not found"}}`, req.ID)
+		}
+	}))
+	defer hs.Close()
+	c := &Client{URL: hs.URL + "/mopidy/rpc", HTTPClient: hs.Client()}
+
+	result, err := c.Call("core.mixer.set_volume", json.RawMessage(`{"volume": 30}`))
+	if err != nil || string(result) != "true" {
+		t.Errorf("set_volume: got %s, %v, want true", result, err)
+	}
+
+	_, err = c.Call("core.tracklist.add", json.RawMessage(`{"urls": []}`))
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32603 {
+		t.Errorf("tracklist.add: got %v, want a JSON-RPC error", err)
+	} else if want := `core.tracklist.add: Application error (-32603): ValueError: One of "tracks", "uri" or "uris" must be set`; err.Error() != want {
+		t.Errorf("tracklist.add: got %q, want %q", err, want)
+	}
+
+	if _, err := c.Call("core.playback.dance", nil); err == nil || !strings.Contains(err.Error(), "Method not found (-32601)") {
+		t.Errorf("dance: got %v, want Method not found", err)
+	}
+
+	want := []string{`core.mixer.set_volume {"volume":30}`, `core.tracklist.add {"urls":[]}`, "core.playback.dance "}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Requests: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package ledsign
+
+import (
+	"log"
+	"net/http"
+
+	"foubot2/configuration"
+	"foubot2/tasmota"
+)
+
+// blinkerSink switches the Blinker, a Tasmota device, on while the lab is
+// open, then sends it the commands configured for the new status.
+type blinkerSink struct {
+	cfg    configuration.Blinker
+	device *tasmota.Client
+}
+
+func newBlinkerSink(cfg *configuration.Config, nc *http.Client) *blinkerSink {
+	return &blinkerSink{
+		cfg: cfg.Blinker,
+		device: &tasmota.Client{
+			URL:        cfg.Blinker.URL,
+			Password:   cfg.Blinker.Password.Value(),
+			HTTPClient: nc,
+		},
+	}
+}
+
+func (s *blinkerSink) Name() string { return "blinker" }
+
+func (s *blinkerSink) OnStatusChange(c StatusChange) (string, error) {
+	if !c.Changed {
+		return "", ErrSkipped
+	}
+	return s.set(c.Open)
+}
+
+func (s *blinkerSink) OnEventStarting(event string) (string, error) {
+	return "", ErrSkipped
+}
+
+func (s *blinkerSink) set(open bool) (string, error) {
+	if err := s.device.SetPower(open); err != nil {
+		return "", err
+	}
+	commands := s.cfg.Closed
+	if open {
+		commands = s.cfg.Open
+	}
+	for _, cmd := range commands {
+		if _, err := s.device.Command(cmd); err != nil {
+			return "", err
+		}
+	}
+	return "POWER " + tasmota.OnOff(open), nil
+}
+
+// check sets the device again if it isn't on (or off) as it should be for
+// open, eg. because it lost power.
+func (s *blinkerSink) check(open bool) (string, error) {
+	on, err := s.device.Power()
+	if err != nil {
+		return "", err
+	}
+	if on == open {
+		return "POWER " + tasmota.OnOff(on), nil
+	}
+	log.Printf("Blinker is %s but the lab is %s, setting it again", tasmota.OnOff(on), statusString(open))
+	detail, err := s.set(open)
+	if err != nil {
+		return "", err
+	}
+	return detail + " (was " + tasmota.OnOff(on) + ")", nil
+}
+
+// checkBlinker queues a check of the Blinker against the lab status, unless
+// an update is waiting to be retried anyway.
+func (ss *SWITCHSTATE) checkBlinker(cfg *configuration.Config, nc *http.Client, open bool) {
+	if cfg.Blinker.URL == "" || !cfg.SinkEnabled("blinker") {
+		return
+	}
+	s := newBlinkerSink(cfg, sinkClient(cfg, nc, "blinker"))
+	ss.notify([]Sink{s}, func(Sink) (string, error) {
+		if ss.retries.isPending(s.Name()) {
+			return "", ErrSkipped
+		}
+		return s.check(open)
+	})
+}
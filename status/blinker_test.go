@@ -0,0 +1,103 @@
+package ledsign
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"foubot2/configuration"
+)
+
+// fakeTasmota answers Power and Dimmer commands like a Tasmota plug.
+type fakeTasmota struct {
+	mu       sync.Mutex
+	power    string
+	commands []string
+}
+
+func (f *fakeTasmota) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := r.FormValue("cmnd")
+	f.commands = append(f.commands, cmd)
+	switch {
+	case cmd == "Power On" || cmd == "Power Off":
+		f.power = strings.ToUpper(strings.TrimPrefix(cmd, "Power "))
+	case strings.HasPrefix(cmd, "Dimmer "):
+		fmt.Fprintf(w, `{"Dimmer": %s}`, strings.TrimPrefix(cmd, "Dimmer "))
+		return
+	}
+	fmt.Fprintf(w, `{"POWER": %q}`, f.power)
+}
+
+func (f *fakeTasmota) setPower(power string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.power = power
+}
+
+func (f *fakeTasmota) getCommands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func TestBlinker(t *testing.T) {
+	device := &fakeTasmota{power: "OFF"}
+	hs := httptest.NewServer(device)
+	defer hs.Close()
+
+	withTestConfig(t, `{"sinks": ["irc", "blinker"], "gpio": {"backend": "fake", "debounce": "0s"},
+		"blinker": {"url": "`+hs.URL+`/", "open": ["Dimmer 80"], "closed": ["Dimmer 10"]}}`)
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	// The check at startup follows the update for the new status.
+	waitFor(t, "Blinker on", func() bool {
+		return len(device.getCommands()) == 3
+	})
+	gpio.SetInput(23, false)
+	waitFor(t, "Blinker off", func() bool {
+		return len(device.getCommands()) == 5
+	})
+	want := []string{"Power On", "Dimmer 80", "Power", "Power Off", "Dimmer 10"}
+	if got := device.getCommands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Commands: got %q, want %q", got, want)
+	}
+}
+
+func TestBlinkerCheck(t *testing.T) {
+	device := &fakeTasmota{power: "ON"}
+	hs := httptest.NewServer(device)
+	defer hs.Close()
+
+	cfg, err := configuration.Parse([]byte(`{"blinker": {"url": "` + hs.URL + `/", "open": ["Dimmer 80"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newBlinkerSink(cfg, hs.Client())
+
+	if detail, err := s.check(true); detail != "POWER ON" || err != nil {
+		t.Errorf("check: got %q, %v, want POWER ON", detail, err)
+	}
+
+	// Power cut.
+	device.setPower("OFF")
+	if detail, err := s.check(true); detail != "POWER ON (was OFF)" || err != nil {
+		t.Errorf("check after power cut: got %q, %v, want POWER ON (was OFF)", detail, err)
+	}
+	want := []string{"Power", "Power", "Power On", "Dimmer 80"}
+	if got := device.getCommands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Commands: got %q, want %q", got, want)
+	}
+}
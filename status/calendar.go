@@ -13,20 +13,36 @@ import (
 )
 
 type Calendar struct {
-	Clock       clockwork.Clock
-	HTTPClient  *http.Client
+	Clock      clockwork.Clock
+	HTTPClient *http.Client
+
+	// Guarded by muConfig once started, see Reload.
 	URL         string
 	GetInterval time.Duration
 
 	NextEvent     chan string
 	StartingEvent chan string
 
+	muConfig sync.Mutex
+	reload   chan struct{}
+
 	wgGet   sync.WaitGroup
 	stopGet chan struct{}
 
 	muTimer   sync.Mutex
 	wgTimer   sync.WaitGroup
 	stopTimer chan struct{}
+
+	// The last parsed events, by start time.
+	muEvents sync.Mutex
+	events   []gocal.Event
+}
+
+// Event is a calendar event, see Calendar.Upcoming.
+type Event struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
 }
 
 type eventsByStart []gocal.Event
@@ -48,23 +64,52 @@ func (c *Calendar) Start() {
 	c.NextEvent = make(chan string)
 	c.StartingEvent = make(chan string)
 	c.stopGet = make(chan struct{})
+	c.reload = make(chan struct{}, 1)
 	c.wgGet.Add(1)
 	go c.getLoop()
 }
 
+// Reload changes the calendar URL and fetch interval, and if either changed,
+// fetches the calendar again right away.
+func (c *Calendar) Reload(url string, getInterval time.Duration) {
+	c.muConfig.Lock()
+	changed := c.URL != url || c.GetInterval != getInterval
+	c.URL, c.GetInterval = url, getInterval
+	c.muConfig.Unlock()
+
+	if changed {
+		select {
+		case c.reload <- struct{}{}:
+		default:
+			// Already pending.
+		}
+	}
+}
+
 func (c *Calendar) getLoop() {
 	var sleep time.Duration
-	var lastModified string
+	var lastModified, lastURL string
 	defer c.wgGet.Done()
 	for {
 		log.Printf("Calendar sleeping %s", sleep)
 		select {
 		case <-c.stopGet:
 			return
+		case <-c.reload:
+			log.Printf("Calendar configuration changed")
 		case <-c.Clock.After(sleep):
 		}
 
-		req, err := http.NewRequest("GET", c.URL, nil)
+		c.muConfig.Lock()
+		url, getInterval := c.URL, c.GetInterval
+		c.muConfig.Unlock()
+		if url != lastURL {
+			// Last-Modified of a different calendar is meaningless.
+			lastModified = ""
+			lastURL = url
+		}
+
+		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			log.Panicf("NewRequest: %s", err)
 		}
@@ -74,6 +119,7 @@ func (c *Calendar) getLoop() {
 		resp, err := c.HTTPClient.Do(req)
 		if err != nil {
 			log.Printf("Calendar get: %s", err)
+			calendarFetches.Inc("error")
 			sleep = 1 * time.Minute
 			continue
 		}
@@ -83,17 +129,23 @@ func (c *Calendar) getLoop() {
 		case http.StatusOK:
 			err = c.parse(resp.Body)
 			if err == nil {
+				calendarFetches.Inc("ok")
 				lastModified = resp.Header.Get("Last-Modified")
 				log.Printf("Last modified now: %s", lastModified)
-				sleep = c.GetInterval
+				sleep = getInterval
 			} else {
+				calendarFetches.Inc("parse_error")
 				sleep = 1 * time.Minute
 			}
 			resp.Body.Close()
 		case http.StatusNotModified:
-			sleep = c.GetInterval
+			calendarFetches.Inc("not_modified")
+			resp.Body.Close()
+			sleep = getInterval
 		default:
 			log.Printf("Unexpected response %d", resp.StatusCode)
+			calendarFetches.Inc("http_error")
+			resp.Body.Close()
 			sleep = 1 * time.Minute
 		}
 	}
@@ -128,6 +180,10 @@ func (c *Calendar) parse(r io.Reader) error {
 		c.wgTimer.Wait()
 	}
 
+	c.muEvents.Lock()
+	c.events = events
+	c.muEvents.Unlock()
+
 	c.stopTimer = make(chan struct{})
 	c.wgTimer.Add(1)
 	go c.timerLoop(events)
@@ -178,6 +234,28 @@ func (c *Calendar) timerLoop(events []gocal.Event) {
 	}
 }
 
+// Upcoming returns the events of the last fetched calendar which haven't
+// started yet, by start time. Only the 30 days after the fetch are parsed, see
+// parse.
+func (c *Calendar) Upcoming() []Event {
+	c.muEvents.Lock()
+	defer c.muEvents.Unlock()
+
+	now := c.Clock.Now()
+	var upcoming []Event
+	for _, e := range c.events {
+		if e.Start.Before(now) {
+			continue
+		}
+		ev := Event{Summary: e.Summary, Start: *e.Start}
+		if e.End != nil {
+			ev.End = *e.End
+		}
+		upcoming = append(upcoming, ev)
+	}
+	return upcoming
+}
+
 func (c *Calendar) Close() {
 	c.muTimer.Lock()
 	close(c.stopTimer)
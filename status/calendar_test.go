@@ -12,6 +12,16 @@ import (
 	"time"
 )
 
+// icalTime formats t for DTSTART;TZID=America/New_York, whatever the local
+// time zone.
+func icalTime(t time.Time) string {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic(err)
+	}
+	return t.In(ny).Format("20060102T150405")
+}
+
 func newServerWithCalendar(time1, time2 time.Time) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, `
@@ -34,8 +44,7 @@ SUMMARY:Event 2
 END:VEVENT
 
 END:VCALENDAR
-`, time1.Format("20060102T150405"), time1.Format("20060102T150405"),
-			time2.Format("20060102T150405"), time2.Format("20060102T150405"))
+`, icalTime(time1), icalTime(time1), icalTime(time2), icalTime(time2))
 	}))
 }
 
@@ -134,7 +143,7 @@ SUMMARY:Event 1
 END:VEVENT
 
 END:VCALENDAR
-	`, time1.Format("20060102T150405"), time1.Format("20060102T150405"))
+	`, icalTime(time1), icalTime(time1))
 	calendarMu.Unlock()
 
 	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -180,7 +189,7 @@ SUMMARY:Event 2
 END:VEVENT
 
 END:VCALENDAR
-	`, time2.Format("20060102T150405"), time2.Format("20060102T150405"))
+	`, icalTime(time2), icalTime(time2))
 	calendarMu.Unlock()
 
 	nextEvent = <-cal.NextEvent
@@ -203,3 +212,40 @@ func TestLive(t *testing.T) {
 	// Can't assert anything, there may be a next event, or not.
 	cal.Close()
 }
+
+func TestCalendarReload(t *testing.T) {
+	start := time.Now()
+	time1 := start.Add(1 * time.Hour)
+	time2 := time1.Add(1 * time.Hour)
+
+	hs1 := newServerWithCalendar(time1, time2)
+	// Event 1 already happened.
+	hs2 := newServerWithCalendar(start.Add(-1*time.Hour), time2)
+
+	cal := &Calendar{
+		Clock:       clockwork.NewRealClock(),
+		HTTPClient:  hs1.Client(),
+		URL:         hs1.URL,
+		GetInterval: 60 * time.Minute,
+	}
+	cal.Start()
+	nextEvent := <-cal.NextEvent
+	log.Printf("Next event: %s", nextEvent)
+	if nextEvent != "Event 1" {
+		t.Errorf("Next event: got %q, want %q", nextEvent, "Event 1")
+	}
+
+	upcoming := cal.Upcoming()
+	if len(upcoming) != 2 || upcoming[0].Summary != "Event 1" || !upcoming[0].Start.Equal(time1.Truncate(time.Second)) {
+		t.Errorf("Upcoming: got %+v, want Event 1 at %s and Event 2", upcoming, time1)
+	}
+
+	// Fetched again right away, not after GetInterval.
+	cal.Reload(hs2.URL, 60*time.Minute)
+	nextEvent = <-cal.NextEvent
+	log.Printf("Next event: %s", nextEvent)
+	if nextEvent != "Event 2" {
+		t.Errorf("Next event: got %q, want %q", nextEvent, "Event 2")
+	}
+	cal.Close()
+}
@@ -0,0 +1,64 @@
+package ledsign
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Debouncer filters readings of an input, so that a new level only counts once
+// it has been read continuously for Hold. Levels that go away sooner are
+// glitches (contact bounce, interference) and are ignored.
+type Debouncer struct {
+	Name string
+	Hold time.Duration
+
+	// Number of rejected glitches.
+	Glitches int
+
+	stable      bool
+	initialized bool
+
+	// A level different from stable, waiting to last for Hold.
+	pending      bool
+	pendingSince time.Time
+}
+
+// Update feeds a reading taken at now, and returns the debounced level. The
+// first reading is taken as stable right away.
+func (d *Debouncer) Update(level bool, now time.Time) bool {
+	if !d.initialized {
+		d.stable = level
+		d.initialized = true
+		return d.stable
+	}
+
+	if level == d.stable {
+		if d.pending {
+			d.Glitches++
+			gpioGlitches.Inc(strings.ToLower(d.Name))
+			log.Printf("%s: ignored glitch to %v for %s (glitches: %d)", d.Name, !level, now.Sub(d.pendingSince), d.Glitches)
+			d.pending = false
+		}
+		return d.stable
+	}
+
+	if !d.pending {
+		d.pending = true
+		d.pendingSince = now
+	}
+	if now.Sub(d.pendingSince) >= d.Hold {
+		d.stable = level
+		d.pending = false
+	}
+	return d.stable
+}
+
+// Deadline returns when a pending level will have lasted for Hold. The input
+// should be read again then, to accept it.
+func (d *Debouncer) Deadline() (time.Time, bool) {
+	if !d.pending {
+		return time.Time{}, false
+	}
+	return d.pendingSince.Add(d.Hold), true
+}
@@ -0,0 +1,118 @@
+package ledsign
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"foubot2/metrics"
+)
+
+type reading struct {
+	ms    int
+	level bool
+	want  bool
+}
+
+func TestDebouncer(t *testing.T) {
+	// The counter is global: a new input for every run of the test.
+	name := fmt.Sprintf("test%d", time.Now().UnixNano())
+	for _, tc := range []struct {
+		name     string
+		hold     time.Duration
+		readings []reading
+		glitches int
+	}{
+		{
+			name: "first reading is stable",
+			hold: 100 * time.Millisecond,
+			readings: []reading{
+				{0, true, true},
+			},
+		},
+		{
+			name: "clean press, polled every second",
+			hold: 100 * time.Millisecond,
+			readings: []reading{
+				{0, false, false},
+				{1000, true, false},
+				{2000, true, true},
+				{3000, true, true},
+			},
+		},
+		{
+			name: "spike between polls",
+			hold: 100 * time.Millisecond,
+			readings: []reading{
+				{0, true, true},
+				{1000, false, true},
+				{2000, true, true},
+				{3000, true, true},
+			},
+			glitches: 1,
+		},
+		{
+			name: "contact bounce, read on every edge",
+			hold: 50 * time.Millisecond,
+			readings: []reading{
+				{0, false, false},
+				{1000, true, false},
+				{1002, false, false},
+				{1003, true, false},
+				{1007, false, false},
+				{1008, true, false},
+				// Read again at the deadline.
+				{1058, true, true},
+				{2000, true, true},
+			},
+			glitches: 2,
+		},
+		{
+			name: "no hold",
+			hold: 0,
+			readings: []reading{
+				{0, false, false},
+				{1000, true, true},
+				{1001, false, false},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Debouncer{Name: name, Hold: tc.hold}
+			start := time.Now()
+			for _, r := range tc.readings {
+				got := d.Update(r.level, start.Add(time.Duration(r.ms)*time.Millisecond))
+				if got != r.want {
+					t.Errorf("At %dms, read %v: got %v, want %v", r.ms, r.level, got, r.want)
+				}
+			}
+			if d.Glitches != tc.glitches {
+				t.Errorf("Glitches: got %d, want %d", d.Glitches, tc.glitches)
+			}
+		})
+	}
+
+	var b strings.Builder
+	metrics.Write(&b)
+	if want := fmt.Sprintf("foubot2_gpio_glitches_total{input=%q} 3\n", name); !strings.Contains(b.String(), want) {
+		t.Errorf("Metrics: missing %q", want)
+	}
+}
+
+func TestDebouncerDeadline(t *testing.T) {
+	d := &Debouncer{Name: "Test", Hold: 50 * time.Millisecond}
+	start := time.Now()
+	d.Update(false, start)
+	if _, ok := d.Deadline(); ok {
+		t.Errorf("Deadline: got pending, want none")
+	}
+	d.Update(true, start.Add(time.Second))
+	deadline, ok := d.Deadline()
+	if want := start.Add(time.Second + 50*time.Millisecond); !ok || !deadline.Equal(want) {
+		t.Errorf("Deadline: got %v (ok %v), want %v", deadline, ok, want)
+	}
+	if !d.Update(true, deadline) {
+		t.Errorf("Update at deadline: got false, want true")
+	}
+}
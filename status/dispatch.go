@@ -0,0 +1,127 @@
+package ledsign
+
+import (
+	"log"
+	"sync"
+)
+
+// dispatcher runs jobs one at a time, in order, on its own goroutine, so that
+// slow network calls don't hold up the status loop.
+type dispatcher struct {
+	mu   sync.Mutex
+	jobs []func()
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go d.loop()
+	return d
+}
+
+// run queues job. It never blocks.
+func (d *dispatcher) run(job func()) {
+	d.mu.Lock()
+	d.jobs = append(d.jobs, job)
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dispatcher) loop() {
+	defer close(d.done)
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		}
+
+		for {
+			d.mu.Lock()
+			if len(d.jobs) == 0 {
+				d.mu.Unlock()
+				break
+			}
+			job := d.jobs[0]
+			d.jobs = d.jobs[1:]
+			d.mu.Unlock()
+
+			job()
+
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// close waits for the running job, if any. Jobs still queued are dropped.
+func (d *dispatcher) close() {
+	close(d.stop)
+	<-d.done
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.jobs) > 0 {
+		log.Printf("Dropping %d queued updates", len(d.jobs))
+	}
+}
+
+// workers are dispatchers by name, started on first use, so that a slow output
+// only holds up its own updates. The zero value is ready to use.
+type workers struct {
+	mu     sync.Mutex
+	m      map[string]*dispatcher
+	closed bool
+}
+
+// run queues job on the dispatcher called name. It never blocks.
+func (w *workers) run(name string, job func()) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		log.Printf("Dropping update of %s after close", name)
+		return
+	}
+	d, ok := w.m[name]
+	if !ok {
+		if w.m == nil {
+			w.m = make(map[string]*dispatcher)
+		}
+		d = newDispatcher()
+		w.m[name] = d
+	}
+	w.mu.Unlock()
+	d.run(job)
+}
+
+// close closes all the dispatchers, waiting for their running jobs
+// concurrently.
+func (w *workers) close() {
+	w.mu.Lock()
+	w.closed = true
+	m := w.m
+	w.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, d := range m {
+		wg.Add(1)
+		go func(d *dispatcher) {
+			defer wg.Done()
+			d.close()
+		}(d)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package ledsign
+
+import (
+	"log"
+	"time"
+)
+
+// doorbell detects presses of the doorbell input.
+type doorbell struct {
+	pin       input
+	debouncer Debouncer
+
+	started  bool
+	pressed  bool
+	lastRing time.Time
+}
+
+// How often to read the doorbell if it doesn't report edges. A press is short:
+// polling it every second with the button would miss most of them.
+const doorbellPoll = 10 * time.Millisecond
+
+func newDoorbell(pin input) *doorbell {
+	return &doorbell{pin: pin, debouncer: Debouncer{Name: "Doorbell"}}
+}
+
+// update reads the doorbell, and returns true if it was just pressed and should
+// be announced: not more than once per cooldown.
+func (d *doorbell) update(now time.Time, hold, cooldown time.Duration) bool {
+	d.debouncer.Hold = hold
+	pressed := d.debouncer.Update(d.pin.Active(), now)
+	wasPressed := d.pressed
+	d.pressed = pressed
+	if !d.started {
+		// Held down at startup doesn't count.
+		d.started = true
+		return false
+	}
+	if !pressed || wasPressed {
+		return false
+	}
+
+	if !d.lastRing.IsZero() && now.Sub(d.lastRing) < cooldown {
+		log.Printf("Doorbell rang, %s after the last announcement; not announcing (cooldown %s)", now.Sub(d.lastRing).Round(time.Second), cooldown)
+		return false
+	}
+	log.Printf("Doorbell rang")
+	d.lastRing = now
+	return true
+}
+
+// pollEdges reads pin every interval until ss stops, and reports the changes
+// of level like an EdgeInput.
+func (ss *SWITCHSTATE) pollEdges(pin InputPin, interval time.Duration) <-chan Edge {
+	edges := make(chan Edge, 16)
+	ss.wg.Add(1)
+	go func() {
+		defer ss.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		level := pin.Read()
+		for {
+			select {
+			case <-ss.ChStop:
+				return
+			case now := <-t.C:
+				if l := pin.Read(); l != level {
+					level = l
+					select {
+					case edges <- Edge{Time: now, Rising: l}:
+					case <-ss.ChStop:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return edges
+}
@@ -0,0 +1,233 @@
+package ledsign
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	rpio "github.com/stianeikeland/go-rpio/v4"
+)
+
+// GPIO gives access to the pins the status logic reads and drives. Levels are
+// true for high, false for low.
+type GPIO interface {
+	// Input configures pin n as an input.
+	Input(n int, pull Pull) (InputPin, error)
+	// Output configures pin n as an output, starting at the given level.
+	Output(n int, high bool) (OutputPin, error)
+	Close() error
+}
+
+type InputPin interface {
+	Read() bool
+}
+
+// EdgeInput is an InputPin which also reports changes of level, so that it
+// doesn't need to be polled. The channel is closed when the pin is released.
+type EdgeInput interface {
+	InputPin
+	Edges() <-chan Edge
+}
+
+// Edge is a change of level on an input.
+type Edge struct {
+	Time   time.Time
+	Rising bool
+}
+
+type OutputPin interface {
+	Write(high bool)
+}
+
+type Pull int
+
+const (
+	PullNone Pull = iota
+	PullUp
+	PullDown
+)
+
+func (p Pull) String() string {
+	switch p {
+	case PullNone:
+		return "none"
+	case PullUp:
+		return "up"
+	case PullDown:
+		return "down"
+	}
+	return fmt.Sprintf("Pull(%d)", int(p))
+}
+
+// OpenGPIO opens the GPIO backend by name: "rpio" (Raspberry Pi, /dev/gpiomem),
+// "cdev" (Linux GPIO character device, eg. /dev/gpiochip0) or "fake" (no
+// hardware, see FakeGPIO).
+func OpenGPIO(backend, chip string) (GPIO, error) {
+	switch backend {
+	case "rpio":
+		return OpenRPIO()
+	case "cdev":
+		return OpenCdev(chip)
+	case "fake":
+		return NewFakeGPIO(), nil
+	}
+	return nil, fmt.Errorf("unknown GPIO backend %q", backend)
+}
+
+type rpioGPIO struct{}
+
+// OpenRPIO opens the Raspberry Pi GPIO through go-rpio.
+func OpenRPIO() (GPIO, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, err
+	}
+	return rpioGPIO{}, nil
+}
+
+func (rpioGPIO) Input(n int, pull Pull) (InputPin, error) {
+	pin := rpio.Pin(n)
+	pin.Input()
+	switch pull {
+	case PullNone:
+		pin.PullOff()
+	case PullUp:
+		pin.PullUp()
+	case PullDown:
+		pin.PullDown()
+	}
+	return rpioPin(pin), nil
+}
+
+func (rpioGPIO) Output(n int, high bool) (OutputPin, error) {
+	pin := rpio.Pin(n)
+	pin.Output()
+	rpioPin(pin).Write(high)
+	return rpioPin(pin), nil
+}
+
+func (rpioGPIO) Close() error {
+	return rpio.Close()
+}
+
+type rpioPin rpio.Pin
+
+func (p rpioPin) Read() bool {
+	return rpio.Pin(p).Read() == rpio.High
+}
+
+func (p rpioPin) Write(high bool) {
+	if high {
+		rpio.Pin(p).High()
+	} else {
+		rpio.Pin(p).Low()
+	}
+}
+
+// FakeGPIO is an in-memory GPIO, for tests and for running without hardware.
+// Tests set input levels with SetInput and check output levels with Level.
+type FakeGPIO struct {
+	mu   sync.Mutex
+	pins map[int]*fakePin
+}
+
+type fakePin struct {
+	gpio   *FakeGPIO
+	n      int
+	output bool
+	pull   Pull
+	edges  chan Edge
+
+	// For inputs, set by SetInput; until then, follows the pull.
+	level    bool
+	levelSet bool
+}
+
+func NewFakeGPIO() *FakeGPIO {
+	return &FakeGPIO{pins: make(map[int]*fakePin)}
+}
+
+func (f *FakeGPIO) pin(n int) *fakePin {
+	p, ok := f.pins[n]
+	if !ok {
+		p = &fakePin{gpio: f, n: n}
+		f.pins[n] = p
+	}
+	return p
+}
+
+func (f *FakeGPIO) Input(n int, pull Pull) (InputPin, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.pin(n)
+	p.output = false
+	p.pull = pull
+	if p.edges == nil {
+		p.edges = make(chan Edge, 16)
+	}
+	return p, nil
+}
+
+func (f *FakeGPIO) Output(n int, high bool) (OutputPin, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.pin(n)
+	p.output = true
+	p.level = high
+	return p, nil
+}
+
+func (f *FakeGPIO) Close() error {
+	return nil
+}
+
+// SetInput sets the level read from input pin n, and reports an edge if it
+// changed.
+func (f *FakeGPIO) SetInput(n int, high bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.pin(n)
+	changed := p.read() != high
+	p.level = high
+	p.levelSet = true
+	if changed && p.edges != nil {
+		select {
+		case p.edges <- Edge{Time: time.Now(), Rising: high}:
+		default:
+		}
+	}
+}
+
+// Level returns the level of output pin n. ok is false if pin n is not
+// configured as an output.
+func (f *FakeGPIO) Level(n int) (high bool, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.pins[n]
+	if !ok || !p.output {
+		return false, false
+	}
+	return p.level, true
+}
+
+func (p *fakePin) Read() bool {
+	p.gpio.mu.Lock()
+	defer p.gpio.mu.Unlock()
+	return p.read()
+}
+
+func (p *fakePin) read() bool {
+	if !p.levelSet && !p.output {
+		return p.pull == PullUp
+	}
+	return p.level
+}
+
+func (p *fakePin) Edges() <-chan Edge {
+	return p.edges
+}
+
+func (p *fakePin) Write(high bool) {
+	p.gpio.mu.Lock()
+	defer p.gpio.mu.Unlock()
+	p.level = high
+}
@@ -0,0 +1,274 @@
+package ledsign
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Linux GPIO character device uAPI v2, from <linux/gpio.h>.
+// https://docs.kernel.org/userspace-api/gpio/chardev.html
+
+const (
+	gpioV2LinesMax        = 64
+	gpioMaxNameSize       = 32
+	gpioV2LineNumAttrsMax = 10
+
+	gpioV2LineFlagInput              = 1 << 2
+	gpioV2LineFlagOutput             = 1 << 3
+	gpioV2LineFlagEdgeRising         = 1 << 4
+	gpioV2LineFlagEdgeFalling        = 1 << 5
+	gpioV2LineFlagBiasPullUp         = 1 << 8
+	gpioV2LineFlagBiasPullDown       = 1 << 9
+	gpioV2LineFlagBiasDisabled       = 1 << 10
+	gpioV2LineFlagEventClockRealtime = 1 << 11
+
+	gpioV2LineAttrIDOutputValues = 2
+
+	gpioV2LineEventRisingEdge = 1
+
+	gpioV2LineEventSize = 48
+)
+
+type gpioV2LineAttribute struct {
+	id      uint32
+	padding uint32
+	// Union of flags, values and debounce_period_us.
+	value uint64
+}
+
+type gpioV2LineConfigAttribute struct {
+	attr gpioV2LineAttribute
+	mask uint64
+}
+
+type gpioV2LineConfig struct {
+	flags    uint64
+	numAttrs uint32
+	padding  [5]uint32
+	attrs    [gpioV2LineNumAttrsMax]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	offsets         [gpioV2LinesMax]uint32
+	consumer        [gpioMaxNameSize]byte
+	config          gpioV2LineConfig
+	numLines        uint32
+	eventBufferSize uint32
+	padding         [5]uint32
+	fd              int32
+}
+
+type gpioV2LineValues struct {
+	bits uint64
+	mask uint64
+}
+
+// The structs must have the same size as in C, this fails to compile otherwise.
+var _ [592]byte = [unsafe.Sizeof(gpioV2LineRequest{})]byte{}
+var _ [16]byte = [unsafe.Sizeof(gpioV2LineValues{})]byte{}
+
+func iowr(nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | 0xB4<<8 | nr
+}
+
+var (
+	gpioV2GetLineIoctl       = iowr(0x07, unsafe.Sizeof(gpioV2LineRequest{}))
+	gpioV2LineGetValuesIoctl = iowr(0x0E, unsafe.Sizeof(gpioV2LineValues{}))
+	gpioV2LineSetValuesIoctl = iowr(0x0F, unsafe.Sizeof(gpioV2LineValues{}))
+)
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// cdevGPIO uses the Linux GPIO character device (/dev/gpiochipN). Each pin is
+// requested as its own line, inputs with edge detection.
+type cdevGPIO struct {
+	chip *os.File
+
+	mu    sync.Mutex
+	lines map[int]*cdevLine
+}
+
+type cdevLine struct {
+	f     *os.File
+	n     int
+	edges chan Edge
+}
+
+// OpenCdev opens a GPIO chip, eg. "/dev/gpiochip0".
+func OpenCdev(chip string) (GPIO, error) {
+	f, err := os.OpenFile(chip, os.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &cdevGPIO{chip: f, lines: make(map[int]*cdevLine)}, nil
+}
+
+func (g *cdevGPIO) request(n int, flags uint64, high bool) (*cdevLine, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Pins are configured again on every reconnect; the kernel only allows one
+	// request per line, so release ours first.
+	if old, ok := g.lines[n]; ok {
+		old.close()
+		delete(g.lines, n)
+	}
+
+	var req gpioV2LineRequest
+	req.offsets[0] = uint32(n)
+	req.numLines = 1
+	copy(req.consumer[:], "foubot2")
+	req.config.flags = flags
+	if flags&gpioV2LineFlagOutput != 0 && high {
+		req.config.numAttrs = 1
+		req.config.attrs[0] = gpioV2LineConfigAttribute{
+			attr: gpioV2LineAttribute{id: gpioV2LineAttrIDOutputValues, value: 1},
+			mask: 1,
+		}
+	}
+
+	var err error
+	ctrlErr := rawControl(g.chip, func(fd uintptr) {
+		err = ioctl(fd, gpioV2GetLineIoctl, unsafe.Pointer(&req))
+	})
+	if ctrlErr != nil {
+		return nil, ctrlErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("request line %d: %s", n, err)
+	}
+
+	// Non-blocking, so that the edge reader can be interrupted by Close.
+	if err := syscall.SetNonblock(int(req.fd), true); err != nil {
+		syscall.Close(int(req.fd))
+		return nil, err
+	}
+	line := &cdevLine{
+		f: os.NewFile(uintptr(req.fd), fmt.Sprintf("%s line %d", g.chip.Name(), n)),
+		n: n,
+	}
+	g.lines[n] = line
+	return line, nil
+}
+
+func rawControl(f *os.File, fn func(fd uintptr)) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return rc.Control(fn)
+}
+
+func (g *cdevGPIO) Input(n int, pull Pull) (InputPin, error) {
+	flags := uint64(gpioV2LineFlagInput | gpioV2LineFlagEdgeRising | gpioV2LineFlagEdgeFalling | gpioV2LineFlagEventClockRealtime)
+	switch pull {
+	case PullNone:
+		flags |= gpioV2LineFlagBiasDisabled
+	case PullUp:
+		flags |= gpioV2LineFlagBiasPullUp
+	case PullDown:
+		flags |= gpioV2LineFlagBiasPullDown
+	}
+	line, err := g.request(n, flags, false)
+	if err != nil {
+		return nil, err
+	}
+	line.edges = make(chan Edge, 16)
+	go line.readEdges()
+	return line, nil
+}
+
+func (g *cdevGPIO) Output(n int, high bool) (OutputPin, error) {
+	return g.request(n, gpioV2LineFlagOutput, high)
+}
+
+func (g *cdevGPIO) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for n, line := range g.lines {
+		line.close()
+		delete(g.lines, n)
+	}
+	return g.chip.Close()
+}
+
+func (l *cdevLine) close() {
+	l.f.Close()
+}
+
+func (l *cdevLine) Read() bool {
+	v := gpioV2LineValues{mask: 1}
+	var err error
+	ctrlErr := rawControl(l.f, func(fd uintptr) {
+		err = ioctl(fd, gpioV2LineGetValuesIoctl, unsafe.Pointer(&v))
+	})
+	if ctrlErr != nil {
+		err = ctrlErr
+	}
+	if err != nil {
+		log.Printf("GPIO line %d: get value: %s", l.n, err)
+	}
+	return v.bits&1 != 0
+}
+
+func (l *cdevLine) Write(high bool) {
+	v := gpioV2LineValues{mask: 1}
+	if high {
+		v.bits = 1
+	}
+	var err error
+	ctrlErr := rawControl(l.f, func(fd uintptr) {
+		err = ioctl(fd, gpioV2LineSetValuesIoctl, unsafe.Pointer(&v))
+	})
+	if ctrlErr != nil {
+		err = ctrlErr
+	}
+	if err != nil {
+		log.Printf("GPIO line %d: set value: %s", l.n, err)
+	}
+}
+
+func (l *cdevLine) Edges() <-chan Edge {
+	return l.edges
+}
+
+// readEdges forwards the kernel's edge events until the line is closed.
+func (l *cdevLine) readEdges() {
+	defer close(l.edges)
+	buf := make([]byte, 16*gpioV2LineEventSize)
+	for {
+		n, err := l.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("GPIO line %d: read events: %s", l.n, err)
+			}
+			return
+		}
+		for b := buf[:n]; len(b) >= gpioV2LineEventSize; b = b[gpioV2LineEventSize:] {
+			// struct gpio_v2_line_event; all platforms we run on are
+			// little-endian.
+			e := Edge{
+				Time:   time.Unix(0, int64(binary.LittleEndian.Uint64(b[0:8]))),
+				Rising: binary.LittleEndian.Uint32(b[8:12]) == gpioV2LineEventRisingEdge,
+			}
+			select {
+			case l.edges <- e:
+			default:
+				// Nobody is keeping up; the level is read again anyway.
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package ledsign
+
+import "testing"
+
+func TestIoctlNumbers(t *testing.T) {
+	// From <linux/gpio.h>, as compiled by gcc.
+	for _, tc := range []struct {
+		name      string
+		got, want uintptr
+	}{
+		{"GPIO_V2_GET_LINE_IOCTL", gpioV2GetLineIoctl, 0xC250B407},
+		{"GPIO_V2_LINE_GET_VALUES_IOCTL", gpioV2LineGetValuesIoctl, 0xC010B40E},
+		{"GPIO_V2_LINE_SET_VALUES_IOCTL", gpioV2LineSetValuesIoctl, 0xC010B40F},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %#x, want %#x", tc.name, tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package ledsign
+
+import "fmt"
+
+// OpenCdev is only supported on Linux.
+func OpenCdev(chip string) (GPIO, error) {
+	return nil, fmt.Errorf("GPIO character device is only supported on Linux")
+}
@@ -0,0 +1,122 @@
+package ledsign
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Transition is a change of lab status, as recorded in the history file.
+type Transition struct {
+	Time time.Time `json:"time"`
+	Open bool      `json:"open"`
+	// What changed the status: "button" or "override".
+	Source string `json:"source"`
+}
+
+// appendHistory adds t at the end of the history file (one JSON object per
+// line), creating it if needed.
+func appendHistory(path string, t Transition) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory returns the transitions in the history file, oldest first. A
+// missing file is an empty history.
+func ReadHistory(path string) ([]Transition, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var history []Transition
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var t Transition
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+			// Eg. the last line, cut short by a power failure.
+			log.Printf("History %s:%d: %s", path, line, err)
+			continue
+		}
+		history = append(history, t)
+	}
+	return history, scanner.Err()
+}
+
+// FormatHistory describes the last n transitions, oldest first, with how long
+// each status lasted.
+func FormatHistory(history []Transition, n int, now time.Time) []string {
+	start := len(history) - n
+	if start < 0 {
+		start = 0
+	}
+	var lines []string
+	for i := start; i < len(history); i++ {
+		t := history[i]
+		line := fmt.Sprintf("%s %s", t.Time.Local().Format("Mon 2006-01-02 15:04"), statusString(t.Open))
+		if i+1 < len(history) {
+			line += fmt.Sprintf(" for %s", FormatDuration(history[i+1].Time.Sub(t.Time)))
+		} else {
+			line += fmt.Sprintf(", still (%s so far)", FormatDuration(now.Sub(t.Time)))
+		}
+		if t.Source != "button" {
+			line += fmt.Sprintf(" [%s]", t.Source)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func statusString(open bool) string {
+	if open {
+		return "OPEN"
+	}
+	return "CLOSED"
+}
+
+// FormatDuration formats d to the minute, eg. "2h10m".
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	switch {
+	case h >= 24:
+		return fmt.Sprintf("%dd%dh%dm", h/24, h%24, m)
+	case h > 0:
+		return fmt.Sprintf("%dh%dm", h, m)
+	}
+	return fmt.Sprintf("%dm", m)
+}
+
+// FormatSince formats t relative to now: "19:42" for today, "Mon 19:42"
+// within the last week, "Jan 2 19:42" before that.
+func FormatSince(t, now time.Time) string {
+	t, now = t.Local(), now.Local()
+	y, m, d := t.Date()
+	ny, nm, nd := now.Date()
+	switch {
+	case y == ny && m == nm && d == nd:
+		return t.Format("15:04")
+	case now.Sub(t) < 6*24*time.Hour:
+		return t.Format("Mon 15:04")
+	}
+	return t.Format("Jan 2 15:04")
+}
@@ -0,0 +1,93 @@
+package ledsign
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	history, err := ReadHistory(path)
+	if err != nil || len(history) != 0 {
+		t.Fatalf("ReadHistory of missing file: got %v, %v; want empty", history, err)
+	}
+
+	start := time.Date(2025, 1, 2, 19, 42, 0, 0, time.Local)
+	want := []Transition{
+		{Time: start, Open: true, Source: "button"},
+		{Time: start.Add(2*time.Hour + 10*time.Minute), Open: false, Source: "button"},
+		{Time: start.Add(26 * time.Hour), Open: true, Source: "override"},
+	}
+	for i, tr := range want {
+		if err := appendHistory(path, tr); err != nil {
+			t.Fatalf("appendHistory: %s", err)
+		}
+		if i == 1 {
+			// As if the power was cut while writing.
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(`{"time": "2025-01-0` + "\n")
+			f.Close()
+		}
+	}
+
+	history, err = ReadHistory(path)
+	if err != nil {
+		t.Fatalf("ReadHistory: %s", err)
+	}
+	if len(history) != len(want) {
+		t.Fatalf("ReadHistory: got %d transitions, want %d", len(history), len(want))
+	}
+	for i := range want {
+		if !history[i].Time.Equal(want[i].Time) || history[i].Open != want[i].Open || history[i].Source != want[i].Source {
+			t.Errorf("ReadHistory[%d]: got %+v, want %+v", i, history[i], want[i])
+		}
+	}
+
+	got := FormatHistory(history, 2, start.Add(27*time.Hour))
+	wantLines := []string{
+		"Thu 2025-01-02 21:52 CLOSED for 23h50m",
+		"Fri 2025-01-03 21:42 OPEN, still (1h0m so far) [override]",
+	}
+	if !reflect.DeepEqual(got, wantLines) {
+		t.Errorf("FormatHistory: got %q, want %q", got, wantLines)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{40 * time.Second, "1m"},
+		{2*time.Hour + 10*time.Minute, "2h10m"},
+		{50*time.Hour + 5*time.Minute, "2d2h5m"},
+	} {
+		if got := FormatDuration(tc.d); got != tc.want {
+			t.Errorf("FormatDuration(%s): got %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestFormatSince(t *testing.T) {
+	now := time.Date(2025, 1, 8, 21, 52, 0, 0, time.Local)
+	for _, tc := range []struct {
+		since time.Time
+		want  string
+	}{
+		{time.Date(2025, 1, 8, 19, 42, 0, 0, time.Local), "19:42"},
+		{time.Date(2025, 1, 7, 23, 5, 0, 0, time.Local), "Tue 23:05"},
+		{time.Date(2025, 1, 3, 9, 0, 0, 0, time.Local), "Fri 09:00"},
+		{time.Date(2025, 1, 1, 19, 42, 0, 0, time.Local), "Jan 1 19:42"},
+	} {
+		if got := FormatSince(tc.since, now); got != tc.want {
+			t.Errorf("FormatSince(%s): got %q, want %q", tc.since, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package ledsign
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"foubot2/configuration"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+// runHooks queues the hooks configured for event on ss.hooks. env is added to
+// the environment, on top of the lab status.
+func (ss *SWITCHSTATE) runHooks(cfg *configuration.Config, event string, env map[string]string) {
+	ss.mu.Lock()
+	open, since, source := ss.status, ss.since, ss.source
+	ss.mu.Unlock()
+
+	vars := []string{
+		"FOUBOT2_EVENT=" + event,
+		"FOUBOT2_STATUS=" + statusString(open),
+		"FOUBOT2_SINCE=" + since.Format(time.RFC3339),
+		"FOUBOT2_SOURCE=" + source,
+	}
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+
+	for _, h := range cfg.Hooks {
+		if !h.RunsOn(event) {
+			continue
+		}
+		h := h
+		ss.hooks.run(func() {
+			runHook(ss.hooksCtx, h, vars)
+		})
+	}
+}
+
+func runHook(ctx context.Context, h configuration.Hook, vars []string) {
+	name := h.Name
+	if name == "" {
+		name = filepath.Base(h.Command[0])
+	}
+	timeout := time.Duration(h.Timeout)
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(), vars...)
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			log.Printf("Hook %s: %s", name, line)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("killed after %s", timeout)
+	}
+	if err != nil {
+		log.Printf("Hook %s error: %s", name, err)
+	} else {
+		log.Printf("Hook %s: done in %s", name, time.Since(start).Round(time.Millisecond))
+	}
+	recordResult("hook_"+name, start, "", err)
+}
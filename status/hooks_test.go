@@ -0,0 +1,53 @@
+package ledsign
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"foubot2/configuration"
+)
+
+func TestHooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	hooks, err := json.Marshal([]configuration.Hook{{
+		Name:    "record",
+		Command: []string{"/bin/sh", "-c", `echo "$FOUBOT2_EVENT $FOUBOT2_STATUS $FOUBOT2_SOURCE" >> "$0"`, out},
+		On:      []string{"open", "closed"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "0s"}, "hooks": `+string(hooks)+`}`)
+
+	gpio := NewFakeGPIO()
+	startOpen(t, gpio)
+	gpio.SetInput(23, false)
+
+	want := "open OPEN button\nclosed CLOSED button\n"
+	waitFor(t, "hooks", func() bool {
+		b, _ := ioutil.ReadFile(out)
+		return string(b) == want
+	})
+}
+
+func TestHookTimeout(t *testing.T) {
+	runHook(context.Background(), configuration.Hook{
+		Command: []string{"/bin/sleep", "10"},
+		Timeout: configuration.Duration(100 * time.Millisecond),
+	}, nil)
+
+	var got SinkResult
+	for _, r := range LastResults() {
+		if r.Sink == "hook_sleep" {
+			got = r
+		}
+	}
+	if got.Err == nil || !strings.Contains(got.Err.Error(), "killed after 100ms") {
+		t.Errorf("Result: got %+v, want killed after 100ms", got)
+	}
+}
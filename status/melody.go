@@ -0,0 +1,54 @@
+package ledsign
+
+import (
+	"net/http"
+	"strings"
+
+	"foubot2/configuration"
+	"foubot2/mopidy"
+)
+
+// melodySink calls the Mopidy server configured for the new status or the
+// starting event, eg. to stop the music when the lab closes.
+type melodySink struct {
+	cfg    configuration.Melody
+	server *mopidy.Client
+}
+
+func newMelodySink(cfg *configuration.Config, nc *http.Client) *melodySink {
+	return &melodySink{
+		cfg:    cfg.Melody,
+		server: &mopidy.Client{URL: cfg.Melody.URL, HTTPClient: nc},
+	}
+}
+
+func (s *melodySink) Name() string { return "melody" }
+
+func (s *melodySink) OnStatusChange(c StatusChange) (string, error) {
+	if !c.Changed {
+		return "", ErrSkipped
+	}
+	if c.Open {
+		return s.call(s.cfg.Open)
+	}
+	return s.call(s.cfg.Closed)
+}
+
+func (s *melodySink) OnEventStarting(event string) (string, error) {
+	return s.call(s.cfg.EventStart)
+}
+
+// call makes the calls in order, and stops at the first error.
+func (s *melodySink) call(calls []configuration.MopidyCall) (string, error) {
+	if len(calls) == 0 {
+		return "", ErrSkipped
+	}
+	var methods []string
+	for _, c := range calls {
+		if _, err := s.server.Call(c.Method, c.Params); err != nil {
+			return "", err
+		}
+		methods = append(methods, c.Method)
+	}
+	return strings.Join(methods, ", "), nil
+}
@@ -0,0 +1,97 @@
+package ledsign
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"foubot2/configuration"
+)
+
+// fakeMopidy records the methods called, and fails core.tracklist.add.
+type fakeMopidy struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (f *fakeMopidy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int64           `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	f.methods = append(f.methods, strings.TrimSpace(req.Method+" "+string(req.Params)))
+	f.mu.Unlock()
+	if req.Method == "core.tracklist.add" {
+		fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": %d, "error": {"code": -32603, "message": "Application error", "data": {"type": "ValueError", "message": "No URIs"}}}`, req.ID)
+		return
+	}
+	fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": %d, "result": null}`, req.ID)
+}
+
+func (f *fakeMopidy) getMethods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.methods...)
+}
+
+func TestMelody(t *testing.T) {
+	server := &fakeMopidy{}
+	hs := httptest.NewServer(server)
+	defer hs.Close()
+
+	// Closed keeps its default, core.playback.stop.
+	withTestConfig(t, `{"sinks": ["melody"], "gpio": {"backend": "fake", "debounce": "0s"},
+		"melody": {"url": "`+hs.URL+`/mopidy/rpc", "open": [{"method": "core.mixer.set_volume", "params": {"volume": 30}}, {"method": "core.playback.play"}]}}`)
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	waitFor(t, "music playing", func() bool {
+		return len(server.getMethods()) == 2
+	})
+	gpio.SetInput(23, false)
+	waitFor(t, "music stopped", func() bool {
+		return len(server.getMethods()) == 3
+	})
+	want := []string{`core.mixer.set_volume {"volume":30}`, "core.playback.play", "core.playback.stop"}
+	if got := server.getMethods(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Calls: got %q, want %q", got, want)
+	}
+}
+
+func TestMelodyEventStartError(t *testing.T) {
+	server := &fakeMopidy{}
+	hs := httptest.NewServer(server)
+	defer hs.Close()
+
+	cfg, err := configuration.Parse([]byte(`{"melody": {"url": "` + hs.URL + `/mopidy/rpc", "event_start": [
+		{"method": "core.tracklist.add", "params": {"uris": []}},
+		{"method": "core.playback.play"}
+	]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newMelodySink(cfg, hs.Client())
+
+	_, err = s.OnEventStarting("Hack night")
+	if want := "core.tracklist.add: Application error (-32603): ValueError: No URIs"; err == nil || err.Error() != want {
+		t.Errorf("OnEventStarting: got %v, want %q", err, want)
+	}
+	// Stopped at the first error.
+	if got, want := server.getMethods(), []string{`core.tracklist.add {"uris":[]}`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Calls: got %q, want %q", got, want)
+	}
+}
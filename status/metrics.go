@@ -0,0 +1,33 @@
+package ledsign
+
+import "foubot2/metrics"
+
+var (
+	labOpen = metrics.NewGauge("foubot2_lab_open",
+		"Whether the lab is open (1) or closed (0).")
+	labTransitions = metrics.NewCounter("foubot2_lab_transitions_total",
+		"Changes of lab status, by new status and what changed it.", "status", "source")
+
+	sinkRequests = metrics.NewCounter("foubot2_sink_requests_total",
+		"Updates of outputs (website, Blinker, IRC topic, ...).", "sink")
+	sinkErrors = metrics.NewCounter("foubot2_sink_errors_total",
+		"Failed updates of outputs.", "sink")
+	sinkDuration = metrics.NewHistogram("foubot2_sink_duration_seconds",
+		"How long updates of outputs took.", metrics.DurationBuckets, "sink")
+	sinkPending = metrics.NewGauge("foubot2_sink_pending",
+		"Whether an output has a failed status update waiting to be retried (1) or not (0).", "sink")
+
+	gpioGlitches = metrics.NewCounter("foubot2_gpio_glitches_total",
+		"Input levels ignored for not lasting through gpio.debounce, by input (button, doorbell).", "input")
+
+	calendarFetches = metrics.NewCounter("foubot2_calendar_fetches_total",
+		"Calendar fetches, by result: ok, not_modified, parse_error, http_error (other statuses) or error (no response).", "result")
+)
+
+// statusLabel is the status label value of the metrics.
+func statusLabel(open bool) string {
+	if open {
+		return "open"
+	}
+	return "closed"
+}
@@ -0,0 +1,81 @@
+package ledsign
+
+import (
+	"time"
+
+	"foubot2/configuration"
+)
+
+// nagger decides when to remind that the lab may have been left open.
+type nagger struct {
+	// The time the lab opened, for the session being watched.
+	since time.Time
+	// The last reminder for it, zero if none yet.
+	last time.Time
+}
+
+// firstNag returns when to first remind about the lab being open since since,
+// zero for never.
+func firstNag(c configuration.Nag, since time.Time) time.Time {
+	var first time.Time
+	if !c.After.IsZero() {
+		first = c.After.Next(since.Local())
+	}
+	if c.OpenFor > 0 {
+		t := since.Add(time.Duration(c.OpenFor))
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+	return first
+}
+
+// due reports whether to remind now, the lab being open since since, and if
+// so counts the reminder as sent.
+func (n *nagger) due(c configuration.Nag, since, now time.Time) bool {
+	if !since.Equal(n.since) {
+		n.since = since
+		n.last = time.Time{}
+	}
+	var next time.Time
+	if n.last.IsZero() {
+		next = firstNag(c, since)
+	} else if c.Repeat > 0 {
+		next = n.last.Add(time.Duration(c.Repeat))
+	}
+	if next.IsZero() || now.Before(next) {
+		return false
+	}
+	n.last = now
+	return true
+}
+
+// AckNag stops the reminders until the lab closes. It returns false if there
+// was no reminder to acknowledge.
+func (ss *SWITCHSTATE) AckNag() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if !ss.nagging {
+		return false
+	}
+	ss.nagging = false
+	ss.nagAcked = ss.since
+	// Wake up processStatus to save it.
+	select {
+	case ss.acks <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// startNag reports whether the reminders about the lab being open since since
+// are still wanted, and if so marks them as going on.
+func (ss *SWITCHSTATE) startNag(since time.Time) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.nagAcked.Equal(since) {
+		return false
+	}
+	ss.nagging = true
+	return true
+}
@@ -0,0 +1,71 @@
+package ledsign
+
+import (
+	"testing"
+	"time"
+
+	"foubot2/configuration"
+)
+
+func TestNagger(t *testing.T) {
+	after, err := configuration.ParseTimeOfDay("23:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2025, 1, day, hour, min, 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		name  string
+		nag   configuration.Nag
+		since time.Time
+		// Checked in order, every minute from since.
+		want []time.Time
+		end  time.Time
+	}{
+		{
+			name:  "after 23:30, repeated",
+			nag:   configuration.Nag{After: after, Repeat: configuration.Duration(time.Hour)},
+			since: at(2, 19, 0),
+			want:  []time.Time{at(2, 23, 30), at(3, 0, 30), at(3, 1, 30)},
+			end:   at(3, 2, 0),
+		},
+		{
+			name:  "opened after 23:30",
+			nag:   configuration.Nag{After: after},
+			since: at(2, 23, 45),
+			want:  []time.Time{at(3, 23, 30)},
+			end:   at(4, 0, 0),
+		},
+		{
+			name:  "open for 12h, before 23:30",
+			nag:   configuration.Nag{After: after, OpenFor: configuration.Duration(12 * time.Hour)},
+			since: at(2, 8, 0),
+			want:  []time.Time{at(2, 20, 0)},
+			end:   at(3, 2, 0),
+		},
+		{
+			name:  "disabled",
+			since: at(2, 8, 0),
+			end:   at(4, 8, 0),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &nagger{}
+			var got []time.Time
+			for now := tc.since; now.Before(tc.end); now = now.Add(time.Minute) {
+				if n.due(tc.nag, tc.since, now) {
+					got = append(got, now)
+				}
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Reminders: got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tc.want[i]) {
+					t.Errorf("Reminder %d: got %s, want %s", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
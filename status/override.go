@@ -0,0 +1,38 @@
+package ledsign
+
+import "time"
+
+// Override is a lab status set by hand (!open, !close), which takes precedence
+// over the button. It ends when it expires, with !auto, or when the button
+// changes.
+type Override struct {
+	Open bool `json:"open"`
+	// Zero if it doesn't expire.
+	Until time.Time `json:"until"`
+	// Who set it, eg. an IRC nick.
+	By string `json:"by"`
+}
+
+func (o *Override) expired(now time.Time) bool {
+	return !o.Until.IsZero() && !now.Before(o.Until)
+}
+
+// SetOverride makes o the lab status, or with nil, goes back to the button.
+func (ss *SWITCHSTATE) SetOverride(o *Override) {
+	select {
+	case ss.overrides <- o:
+	case <-ss.ChStop:
+	}
+}
+
+// GetOverride returns the override in effect, nil if the status follows the
+// button.
+func (ss *SWITCHSTATE) GetOverride() *Override {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.override == nil {
+		return nil
+	}
+	o := *ss.override
+	return &o
+}
@@ -0,0 +1,90 @@
+package ledsign
+
+import (
+	"fmt"
+	"sort"
+
+	"foubot2/configuration"
+)
+
+// input is an input pin with its polarity.
+type input struct {
+	InputPin
+	activeLow bool
+}
+
+func (i input) Active() bool {
+	return i.Read() != i.activeLow
+}
+
+// activeAfter tells whether the pin is active after the edge e.
+func (i input) activeAfter(e Edge) bool {
+	return e.Rising != i.activeLow
+}
+
+// Edges returns the changes of level of the pin, or nil if it must be polled.
+func (i input) Edges() <-chan Edge {
+	if e, ok := i.InputPin.(EdgeInput); ok {
+		return e.Edges()
+	}
+	return nil
+}
+
+// output is an output pin with its polarity.
+type output struct {
+	OutputPin
+	activeLow bool
+}
+
+func (o output) SetActive(active bool) {
+	o.Write(active != o.activeLow)
+}
+
+type pinSet struct {
+	inputs map[string]input
+	// Outputs which are active while the lab is open.
+	followOpen []output
+}
+
+func parsePull(s string) Pull {
+	switch s {
+	case "up":
+		return PullUp
+	case "down":
+		return PullDown
+	}
+	return PullNone
+}
+
+// setupPins configures the pins from the configuration.
+func setupPins(gpio GPIO, pins configuration.Pins) (*pinSet, error) {
+	ps := &pinSet{inputs: make(map[string]input)}
+
+	// Sorted, so that errors and logs are in a stable order.
+	var roles []string
+	for role := range pins {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		p := pins[role]
+		switch p.Direction {
+		case "input":
+			pin, err := gpio.Input(p.Pin, parsePull(p.Pull))
+			if err != nil {
+				return nil, fmt.Errorf("%s (pin %d): %s", role, p.Pin, err)
+			}
+			ps.inputs[role] = input{pin, p.ActiveLow}
+		case "output":
+			pin, err := gpio.Output(p.Pin, p.Initial != p.ActiveLow)
+			if err != nil {
+				return nil, fmt.Errorf("%s (pin %d): %s", role, p.Pin, err)
+			}
+			if p.Follows == "open" {
+				ps.followOpen = append(ps.followOpen, output{pin, p.ActiveLow})
+			}
+		}
+	}
+	return ps, nil
+}
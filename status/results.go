@@ -0,0 +1,91 @@
+package ledsign
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"foubot2/httperr"
+)
+
+// SinkResult is the outcome of the last update of an output (the website, the
+// Blinker, the Mattermost header, ...).
+type SinkResult struct {
+	Sink string
+	Time time.Time
+	// eg. "200 OK". Empty if there was no response.
+	Detail string
+	// Nil if the update succeeded.
+	Err error
+}
+
+// Kept across reconnects, unlike SWITCHSTATE.
+var results struct {
+	mu   sync.Mutex
+	last map[string]SinkResult
+}
+
+// recordResult keeps the outcome of an update of sink which began at start,
+// see LastResults, and counts it in the metrics.
+func recordResult(sink string, start time.Time, detail string, err error) {
+	sinkRequests.Inc(sink)
+	if err != nil {
+		sinkErrors.Inc(sink)
+	}
+	sinkDuration.Observe(time.Since(start).Seconds(), sink)
+
+	results.mu.Lock()
+	defer results.mu.Unlock()
+	if results.last == nil {
+		results.last = make(map[string]SinkResult)
+	}
+	results.last[sink] = SinkResult{Sink: sink, Time: time.Now(), Detail: detail, Err: err}
+}
+
+// recordHTTP keeps the outcome of an HTTP request to sink, see httpResult.
+func recordHTTP(sink string, start time.Time, resp *http.Response, err error) {
+	detail, err := httpResult(resp, err)
+	recordResult(sink, start, detail, err)
+}
+
+// httpResult discards the response body, and describes the response. Statuses
+// other than 2xx are errors.
+func httpResult(resp *http.Response, err error) (string, error) {
+	if err != nil {
+		return "", withoutURL(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Status, httperr.Unexpected(resp)
+	}
+	return resp.Status, nil
+}
+
+// withoutURL drops the URL from the error of an HTTP request: it may hold a
+// secret (status_endpoint, a webhook URL), and the results are served without
+// authentication, see LastResults.
+func withoutURL(err error) error {
+	if e, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s: %w", e.Op, e.Err)
+	}
+	return err
+}
+
+// LastResults returns the outcome of the last update of every output, by
+// name.
+func LastResults() []SinkResult {
+	results.mu.Lock()
+	defer results.mu.Unlock()
+	var last []SinkResult
+	for _, r := range results.last {
+		last = append(last, r)
+	}
+	sort.Slice(last, func(i, j int) bool { return last[i].Sink < last[j].Sink })
+	return last
+}
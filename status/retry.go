@@ -0,0 +1,223 @@
+package ledsign
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"foubot2/configuration"
+	"foubot2/httperr"
+)
+
+// retryQueue keeps, for every sink whose last status update failed, the
+// latest status to deliver to it and when to try again. Only the latest status
+// is kept: a sink which missed OPEN then CLOSED is only told CLOSED.
+type retryQueue struct {
+	mu      sync.Mutex
+	pending map[string]*delivery
+}
+
+type delivery struct {
+	Change   StatusChange `json:"change"`
+	Attempts int          `json:"attempts"`
+	Next     time.Time    `json:"next"`
+
+	// Queued by due, see retry.
+	inFlight bool
+}
+
+// loadRetries reads the deliveries pending when foubot2 stopped. A missing
+// file is an empty queue.
+func loadRetries(path string) (*retryQueue, error) {
+	q := &retryQueue{pending: make(map[string]*delivery)}
+	if path == "" {
+		return q, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal(b, &q.pending); err != nil {
+		return q, err
+	}
+	for sink, d := range q.pending {
+		log.Printf("Sink %s: %s still to be delivered (%d attempts)", sink, statusString(d.Change.Open), d.Attempts)
+		sinkPending.Set(1, sink)
+	}
+	return q, nil
+}
+
+// take returns the status to deliver to sink: c, or if c is nil the pending
+// one. ok is false if c is nil and nothing is pending.
+//
+// A change from before a restart is delivered again if it was pending (eg. the
+// lab opened while the website was down), even though it's not a change
+// anymore.
+func (q *retryQueue) take(sink string, c *StatusChange) (latest StatusChange, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d := q.pending[sink]
+	switch {
+	case c == nil && d == nil:
+		return StatusChange{}, false
+	case c == nil:
+		return d.Change, true
+	case d == nil:
+		return *c, true
+	}
+	latest = *c
+	latest.Changed = latest.Changed || d.Change.Changed
+	return latest, true
+}
+
+// done records the outcome of delivering c to sink: on a transient failure it
+// is tried again later, backing off from retry.min to retry.max.
+func (q *retryQueue) done(cfg *configuration.Config, sink string, c StatusChange, err error, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil && err != ErrSkipped && !transient(err) {
+		log.Printf("Sink %s: not retrying %s, the error is permanent", sink, statusString(c.Open))
+	}
+	if err == nil || err == ErrSkipped || !transient(err) {
+		if _, ok := q.pending[sink]; !ok {
+			return
+		}
+		delete(q.pending, sink)
+		sinkPending.Set(0, sink)
+		q.save(cfg.Retry.File)
+		return
+	}
+
+	d := q.pending[sink]
+	if d == nil {
+		d = &delivery{}
+		q.pending[sink] = d
+	}
+	d.Change = c
+	d.Attempts++
+	wait := backoff(time.Duration(cfg.Retry.Min), time.Duration(cfg.Retry.Max), d.Attempts)
+	d.Next = now.Add(wait)
+	d.inFlight = false
+	log.Printf("Sink %s: retrying %s in %s (attempt %d)", sink, statusString(c.Open), wait, d.Attempts)
+	sinkPending.Set(1, sink)
+	q.save(cfg.Retry.File)
+}
+
+// drop forgets the delivery pending for sink, eg. because it was disabled.
+func (q *retryQueue) drop(cfg *configuration.Config, sink string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, sink)
+	sinkPending.Set(0, sink)
+	q.save(cfg.Retry.File)
+}
+
+// isPending tells whether an update of sink is waiting to be retried.
+func (q *retryQueue) isPending(sink string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.pending[sink]
+	return ok
+}
+
+// due returns the sinks to try again now, in order of name, and marks them so
+// that they aren't returned again until done.
+func (q *retryQueue) due(now time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var sinks []string
+	for sink, d := range q.pending {
+		if !d.inFlight && !now.Before(d.Next) {
+			d.inFlight = true
+			sinks = append(sinks, sink)
+		}
+	}
+	sort.Strings(sinks)
+	return sinks
+}
+
+// save writes the pending deliveries to path, if set. Called with q.mu held.
+func (q *retryQueue) save(path string) {
+	if path == "" {
+		return
+	}
+	if err := writeJSONFile(path, q.pending); err != nil {
+		log.Printf("Save retries: %s", err)
+	}
+}
+
+// transient tells whether an update which failed with err may succeed if tried
+// again: there was no reply, or a server error (5xx). Others, eg. a topic
+// without the lab status, would fail the same way again.
+func transient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	var se *httperr.StatusError
+	return errors.As(err, &se) && (se.Code == 0 || se.Code >= 500)
+}
+
+// backoff is how long to wait before the given attempt: min, then twice as
+// long every time, up to max.
+func backoff(min, max time.Duration, attempts int) time.Duration {
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// deliver tells s about the latest status: c, or if c is nil the one pending
+// for s. Failures are retried later, see retry.
+func (ss *SWITCHSTATE) deliver(cfg *configuration.Config, s Sink, c *StatusChange) (string, error) {
+	latest, ok := ss.retries.take(s.Name(), c)
+	if !ok {
+		// Delivered meanwhile, with a newer status.
+		return "", ErrSkipped
+	}
+	detail, err := s.OnStatusChange(latest)
+	ss.retries.done(cfg, s.Name(), latest, err, time.Now())
+	return detail, err
+}
+
+// retry queues the deliveries which are due on the workers of their sinks.
+func (ss *SWITCHSTATE) retry(cfg *configuration.Config, irccon IRC, nc *http.Client, now time.Time) {
+	names := ss.retries.due(now)
+	if len(names) == 0 {
+		return
+	}
+	due := make(map[string]bool)
+	for _, name := range names {
+		due[name] = true
+	}
+	var sinks []Sink
+	for _, s := range ss.newSinks(cfg, irccon, nc) {
+		if due[s.Name()] {
+			sinks = append(sinks, s)
+			delete(due, s.Name())
+		}
+	}
+	for _, name := range names {
+		if due[name] {
+			log.Printf("Sink %s: no longer enabled, dropping its retry", name)
+			ss.retries.drop(cfg, name)
+		}
+	}
+	ss.notify(sinks, func(s Sink) (string, error) {
+		return ss.deliver(cfg, s, nil)
+	})
+}
@@ -0,0 +1,234 @@
+package ledsign
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"foubot2/configuration"
+	"foubot2/httperr"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// flakyServer records the paths requested, and answers 500 while failing is
+// set.
+type flakyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	failing bool
+	hits    []string
+}
+
+func newFlakyServer(failing bool) *flakyServer {
+	f := &flakyServer{failing: failing}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.hits = append(f.hits, r.URL.Path)
+	}))
+	return f
+}
+
+func (f *flakyServer) setFailing(failing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = failing
+}
+
+// delivered returns the paths requested successfully.
+func (f *flakyServer) delivered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.hits...)
+}
+
+func TestRetryLatestOnly(t *testing.T) {
+	hs := newFlakyServer(true)
+	defer hs.Close()
+
+	withTestConfig(t, `{"sinks": ["website"], "retry": {"min": "1s", "max": "1s"}, "gpio": {"backend": "fake", "debounce": "0s"}, "status_endpoint": "`+hs.URL+`/"}`)
+	cfg := configuration.Get()
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	// OPEN then CLOSED fail; only CLOSED is retried, and kept on disk.
+	waitFor(t, "OPEN pending", func() bool {
+		q, _ := loadRetries(cfg.Retry.File)
+		d := q.pending["website"]
+		return d != nil && d.Change.Open
+	})
+	gpio.SetInput(23, false)
+	waitFor(t, "CLOSED pending", func() bool {
+		q, _ := loadRetries(cfg.Retry.File)
+		d := q.pending["website"]
+		return d != nil && !d.Change.Open
+	})
+
+	hs.setFailing(false)
+	waitFor(t, "CLOSED delivered", func() bool {
+		return len(hs.delivered()) > 0
+	})
+	time.Sleep(1500 * time.Millisecond)
+	if got, want := hs.delivered(), []string{"/CLOSED"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Delivered: got %q, want %q", got, want)
+	}
+	q, err := loadRetries(cfg.Retry.File)
+	if err != nil || len(q.pending) != 0 {
+		t.Errorf("Retries file: got %+v (%v), want empty", q.pending, err)
+	}
+}
+
+func TestRetryAfterRestart(t *testing.T) {
+	hs := newFlakyServer(false)
+	defer hs.Close()
+
+	stateFile := withTestConfig(t, `{"sinks": ["website"], "gpio": {"backend": "fake", "debounce": "0s"}, "status_endpoint": "`+hs.URL+`/"}`)
+	cfg := configuration.Get()
+
+	// The lab opened while the website was down, then foubot2 restarted.
+	since := time.Now().Add(-time.Hour)
+	if err := saveState(stateFile, savedState{Open: true, Since: since}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(cfg.Retry.File, map[string]*delivery{
+		"website": {Change: StatusChange{Open: true, Since: since, Source: "button", Changed: true}, Attempts: 3, Next: since},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB OPEN || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	waitFor(t, "OPEN delivered", func() bool {
+		return len(hs.delivered()) > 0
+	})
+	time.Sleep(100 * time.Millisecond)
+	if got, want := hs.delivered(), []string{"/OPEN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Delivered: got %q, want %q", got, want)
+	}
+}
+
+func TestRetryAfterRestartChanged(t *testing.T) {
+	hs := newFlakyServer(false)
+	defer hs.Close()
+
+	stateFile := withTestConfig(t, `{"sinks": ["website"], "gpio": {"backend": "fake", "debounce": "0s"}, "status_endpoint": "`+hs.URL+`/"}`)
+	cfg := configuration.Get()
+
+	// OPEN was still to be delivered, but the lab closed while foubot2 was
+	// down: only CLOSED is delivered.
+	since := time.Now().Add(-time.Hour)
+	if err := saveState(stateFile, savedState{Open: true, Since: since}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(cfg.Retry.File, map[string]*delivery{
+		"website": {Change: StatusChange{Open: true, Since: since, Source: "button", Changed: true}, Attempts: 3, Next: since},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gpio := NewFakeGPIO()
+	gpio.SetInput(23, false)
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB OPEN || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	waitFor(t, "CLOSED delivered", func() bool {
+		return len(hs.delivered()) > 0
+	})
+	time.Sleep(1500 * time.Millisecond)
+	if got, want := hs.delivered(), []string{"/CLOSED"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Delivered: got %q, want %q", got, want)
+	}
+	q, err := loadRetries(cfg.Retry.File)
+	if err != nil || len(q.pending) != 0 {
+		t.Errorf("Retries file: got %+v (%v), want empty", q.pending, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var got []time.Duration
+	for attempts := 1; attempts <= 8; attempts++ {
+		got = append(got, backoff(10*time.Second, 10*time.Minute, attempts))
+	}
+	want := []time.Duration{
+		10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second,
+		160 * time.Second, 320 * time.Second, 10 * time.Minute, 10 * time.Minute,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backoff: got %v, want %v", got, want)
+	}
+}
+
+func TestRetryTransientOnly(t *testing.T) {
+	cfg, err := configuration.Parse([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := loadRetries("")
+	c := StatusChange{Open: true, Changed: true}
+
+	_, netErr := http.Get("http://127.0.0.1:1/")
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{netErr, true},
+		{&httperr.StatusError{Code: 503, Text: "unexpected status 503 Service Unavailable"}, true},
+		{mattermostError("Get channel", &model.Response{StatusCode: 0}), true},
+		{&httperr.StatusError{Code: 404, Text: "unexpected status 404 Not Found"}, false},
+		{fmt.Errorf("IRC topic %q did not match regexp %q", "Foulab", labStatusRe), false},
+	} {
+		q.done(cfg, "website", c, tc.err, time.Now())
+		if got := q.isPending("website"); got != tc.want {
+			t.Errorf("%v: got pending %v, want %v", tc.err, got, tc.want)
+		}
+		q.done(cfg, "website", c, nil, time.Now())
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer hs.Close()
+
+	cfg, err := configuration.Parse([]byte(`{"blinker": "` + hs.URL + `/", "melody": {"url": "` + hs.URL + `/mopidy/rpc"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := StatusChange{Open: false, Changed: true}
+	for _, s := range []Sink{
+		&websiteSink{nc: hs.Client(), endpoint: hs.URL + "/"},
+		newBlinkerSink(cfg, hs.Client()),
+		newMelodySink(cfg, hs.Client()),
+	} {
+		_, err := s.OnStatusChange(c)
+		if err == nil || !transient(err) {
+			t.Errorf("%s: got %v, want a transient error", s.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package ledsign
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"foubot2/configuration"
+)
+
+// Sink is an output told about the lab status and calendar events: the IRC
+// channel, the website, the Blinker...
+type Sink interface {
+	// For the logs and LastResults, eg. "website".
+	Name() string
+
+	// OnStatusChange is called when the lab opens or closes, and when
+	// (re)connecting with c.Changed false.
+	OnStatusChange(c StatusChange) (detail string, err error)
+
+	// OnEventStarting is called when a calendar event starts.
+	OnEventStarting(event string) (detail string, err error)
+}
+
+// ErrSkipped is returned by a sink with nothing to do. It isn't recorded in
+// LastResults.
+var ErrSkipped = errors.New("skipped")
+
+// StatusChange describes a new lab status.
+type StatusChange struct {
+	Open  bool      `json:"open"`
+	Since time.Time `json:"since"`
+	// What set it: "button" or "override".
+	Source string `json:"source"`
+
+	// First status after (re)connecting.
+	Startup bool `json:"startup"`
+
+	// False if the status is the same as before a restart: the topic and GPIO
+	// are set again (which does nothing if they are right), but the other
+	// outputs are not notified again.
+	Changed bool `json:"changed"`
+}
+
+// newSinks returns the sinks enabled in cfg.Sinks, in order. Sinks whose own
+// settings are empty (eg. no mattermost.server) are left out.
+func (ss *SWITCHSTATE) newSinks(cfg *configuration.Config, irccon IRC, nc *http.Client) []Sink {
+	var sinks []Sink
+	for _, name := range cfg.Sinks {
+		nc := sinkClient(cfg, nc, name)
+		switch name {
+		case "irc":
+			sinks = append(sinks, &ircSink{ss: ss, cfg: cfg, irccon: irccon})
+		case "mattermost":
+			if cfg.Mattermost.Server != "" {
+				sinks = append(sinks, &mattermostSink{ss: ss, cfg: cfg, nc: nc})
+			}
+		case "website":
+			if endpoint := cfg.StatusEndPoint.Value(); endpoint != "" {
+				sinks = append(sinks, &websiteSink{nc: nc, endpoint: endpoint})
+			}
+		case "blinker":
+			if cfg.Blinker.URL != "" {
+				sinks = append(sinks, newBlinkerSink(cfg, nc))
+			}
+		case "melody":
+			if cfg.Melody.URL != "" {
+				sinks = append(sinks, newMelodySink(cfg, nc))
+			}
+		default:
+			// Checked by configuration.Validate.
+			log.Printf("Unknown sink %q", name)
+		}
+	}
+	return sinks
+}
+
+// sinkClient is nc with the timeout of the sink called name.
+func sinkClient(cfg *configuration.Config, nc *http.Client, name string) *http.Client {
+	return &http.Client{Transport: nc.Transport, Timeout: cfg.SinkTimeout(name)}
+}
+
+// notify queues a call of f on every sink, each on its own worker so that a
+// slow sink doesn't hold up the others, and records the results.
+func (ss *SWITCHSTATE) notify(sinks []Sink, f func(Sink) (string, error)) {
+	for _, s := range sinks {
+		s := s
+		ss.sinks.run(s.Name(), func() {
+			start := time.Now()
+			detail, err := f(s)
+			if err == ErrSkipped {
+				return
+			}
+			if err != nil {
+				log.Printf("Sink %s error: %s", s.Name(), err)
+			} else if detail != "" {
+				log.Printf("Sink %s: %s", s.Name(), detail)
+			}
+			recordResult(s.Name(), start, detail, err)
+		})
+	}
+}
+
+// topicStatus is the lab status as shown in the topic, eg. "OPEN since 19:42".
+func topicStatus(cfg *configuration.Config, c StatusChange) string {
+	s := statusString(c.Open)
+	if cfg.Topic.ShowSince {
+		s += " since " + FormatSince(c.Since, time.Now())
+	}
+	return s
+}
+
+// ircSink sets the status in the channel topic, announces changes if
+// topic.send_to_channel is set, and announces calendar events.
+type ircSink struct {
+	ss     *SWITCHSTATE
+	cfg    *configuration.Config
+	irccon IRC
+}
+
+func (s *ircSink) Name() string { return "irc" }
+
+func (s *ircSink) OnStatusChange(c StatusChange) (string, error) {
+	if err := s.ss.updateTopicIRC(s.cfg, s.irccon, labStatusRe, topicStatus(s.cfg, c)); err != nil {
+		return "", err
+	}
+	// Not at startup, to avoid spam.
+	if c.Changed && !c.Startup && s.cfg.Topic.SendToChannel {
+		s.irccon.Privmsg(s.cfg.IRC.Channel, s.cfg.Messages.Announcement.Render(map[string]string{
+			"Status": statusString(c.Open),
+		}))
+	}
+	return "", nil
+}
+
+func (s *ircSink) OnEventStarting(event string) (string, error) {
+	s.irccon.Privmsg(s.cfg.IRC.Channel, s.cfg.Messages.StartingEvent.Render(map[string]string{
+		"Event": event,
+	}))
+	return "", nil
+}
+
+// mattermostSink sets the status in the channel header, and announces
+// calendar events.
+type mattermostSink struct {
+	ss  *SWITCHSTATE
+	cfg *configuration.Config
+	nc  *http.Client
+}
+
+func (s *mattermostSink) Name() string { return "mattermost" }
+
+func (s *mattermostSink) OnStatusChange(c StatusChange) (string, error) {
+	return "", s.ss.updateTopicMattermost(s.cfg, s.nc, labStatusRe, topicStatus(s.cfg, c))
+}
+
+func (s *mattermostSink) OnEventStarting(event string) (string, error) {
+	return "", postMattermost(s.cfg, s.nc, s.cfg.Messages.StartingEvent.Render(map[string]string{
+		"Event": event,
+	}))
+}
+
+// websiteSink gets status_endpoint + "OPEN" or "CLOSED".
+type websiteSink struct {
+	nc       *http.Client
+	endpoint string
+}
+
+func (s *websiteSink) Name() string { return "website" }
+
+func (s *websiteSink) OnStatusChange(c StatusChange) (string, error) {
+	if !c.Changed {
+		return "", ErrSkipped
+	}
+	return httpResult(s.nc.Get(s.endpoint + statusString(c.Open)))
+}
+
+func (s *websiteSink) OnEventStarting(event string) (string, error) {
+	return "", ErrSkipped
+}
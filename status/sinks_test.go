@@ -0,0 +1,132 @@
+package ledsign
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinksEnabled(t *testing.T) {
+	var mu sync.Mutex
+	var hits []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits = append(hits, r.URL.Path)
+	}))
+	defer hs.Close()
+	getHits := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), hits...)
+	}
+
+	// The Blinker is configured but not enabled, and neither is the topic.
+	withTestConfig(t, `{"sinks": ["website"], "blinker": "`+hs.URL+`/blinker/", "gpio": {"backend": "fake", "debounce": "0s"}, "status_endpoint": "`+hs.URL+`/"}`)
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	waitFor(t, "website OPEN", func() bool {
+		return len(getHits()) == 1
+	})
+	gpio.SetInput(23, false)
+	waitFor(t, "website CLOSED", func() bool {
+		return len(getHits()) == 2
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	if got, want := getHits(), []string{"/OPEN", "/CLOSED"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Requests: got %q, want %q", got, want)
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if len(fi.messages) != 0 {
+		t.Errorf("IRC messages: got %q, want none", fi.messages)
+	}
+}
+
+func TestSinkErrSkipped(t *testing.T) {
+	for _, s := range []Sink{&websiteSink{}, &blinkerSink{}, &melodySink{}} {
+		if _, err := s.OnStatusChange(StatusChange{Open: true, Changed: false}); err != ErrSkipped {
+			t.Errorf("%s: unchanged status: got %v, want ErrSkipped", s.Name(), err)
+		}
+		if _, err := s.OnEventStarting("Hack night"); err != ErrSkipped {
+			t.Errorf("%s: event: got %v, want ErrSkipped", s.Name(), err)
+		}
+	}
+	if _, err := (&melodySink{}).OnStatusChange(StatusChange{Open: true, Changed: true}); err != ErrSkipped {
+		t.Errorf("melody: open: got %v, want ErrSkipped", err)
+	}
+}
+
+func TestSlowSinkDoesNotBlockOthers(t *testing.T) {
+	hung := make(chan struct{})
+	website := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer website.Close()
+	defer close(hung)
+	var mu sync.Mutex
+	var blinks []string
+	blinker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		blinks = append(blinks, r.URL.Query().Get("cmnd"))
+	}))
+	defer blinker.Close()
+
+	withTestConfig(t, `{"sinks": ["website", "blinker", "irc"], "sink_timeouts": {"website": "500ms"}, "gpio": {"backend": "fake", "debounce": "0s"},
+		"status_endpoint": "`+website.URL+`/", "blinker": "`+blinker.URL+`/"}`)
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	// The website hangs; the Blinker and the topic are updated meanwhile.
+	start := time.Now()
+	waitFor(t, "topic OPEN", func() bool {
+		return fi.sent("ChanServ: TOPIC #foulab Foulab || LAB OPEN || Next event: (none) ||")
+	})
+	waitFor(t, "Blinker on", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(blinks) > 0 && blinks[0] == "Power On"
+	})
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Errorf("Topic and Blinker took %s, want them before the website times out", d)
+	}
+
+	waitFor(t, "website timeout", func() bool {
+		for _, r := range LastResults() {
+			if r.Sink == "website" && r.Err != nil && r.Time.After(start) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestWebsiteErrorWithoutURL(t *testing.T) {
+	s := &websiteSink{nc: &http.Client{}, endpoint: "http://127.0.0.1:1/s3cret/"}
+	_, err := s.OnStatusChange(StatusChange{Open: true, Changed: true})
+	if err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("OnStatusChange: got %v, want an error without the endpoint", err)
+	}
+	if !transient(err) {
+		t.Errorf("OnStatusChange: got permanent error %v, want transient", err)
+	}
+}
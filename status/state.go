@@ -0,0 +1,65 @@
+package ledsign
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// savedState is the lab status as of the last change, kept across restarts so
+// that reconnecting doesn't look like a change.
+type savedState struct {
+	Open  bool      `json:"open"`
+	Since time.Time `json:"since"`
+
+	Override *Override `json:"override,omitempty"`
+
+	// The last reminder that the lab may have been left open since Since, and
+	// whether it was acknowledged with !ack.
+	LastNag  time.Time `json:"last_nag"`
+	NagAcked bool      `json:"nag_acked,omitempty"`
+}
+
+// loadState reads the state file. ok is false if there is none.
+func loadState(path string) (s savedState, ok bool, err error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, false, err
+	}
+	return s, true, nil
+}
+
+// saveState writes the state file.
+func saveState(path string, s savedState) error {
+	return writeJSONFile(path, s)
+}
+
+// writeJSONFile writes v to path atomically, so that a crash doesn't leave it
+// half-written.
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
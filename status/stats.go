@@ -0,0 +1,206 @@
+package ledsign
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session is a time the lab was open.
+type Session struct {
+	Start, End time.Time
+
+	// Still open, End is "now".
+	ongoing bool
+}
+
+func (s Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Stats is how long the lab was open over a period.
+type Stats struct {
+	From, To time.Time
+
+	// Total time open, and the number of sessions which overlap the period.
+	Open     time.Duration
+	Sessions int
+
+	// Time open on each day of the period, starting with From's, and in
+	// total per weekday.
+	Days     []DayStats
+	Weekdays [7]time.Duration
+
+	// Among the sessions which both started and ended in the period. Zero if
+	// there are none.
+	Longest, Shortest Session
+}
+
+type DayStats struct {
+	Day  time.Time
+	Open time.Duration
+}
+
+// StatsPeriod returns the start and end of a period ("week" or "month") for
+// !stats: the days up to now, today included.
+func StatsPeriod(period string, now time.Time) (from, to time.Time) {
+	days := 7
+	if period == "month" {
+		days = 30
+	}
+	return midnight(now).AddDate(0, 0, -(days - 1)), now
+}
+
+// ReportPeriod returns the start and end of a period ("week" or "month") for
+// the scheduled report: the days up to the last midnight.
+func ReportPeriod(period string, now time.Time) (from, to time.Time) {
+	days := 7
+	if period == "month" {
+		days = 30
+	}
+	to = midnight(now)
+	return to.AddDate(0, 0, -days), to
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// sessions returns the times the lab was open according to history. If it
+// is still open, the last session ends at now.
+func sessions(history []Transition, now time.Time) []Session {
+	var all []Session
+	var open *time.Time
+	for i := range history {
+		t := history[i]
+		switch {
+		case t.Open && open == nil:
+			open = &history[i].Time
+		case !t.Open && open != nil:
+			all = append(all, Session{Start: *open, End: t.Time})
+			open = nil
+		}
+	}
+	if open != nil && open.Before(now) {
+		all = append(all, Session{Start: *open, End: now, ongoing: true})
+	}
+	return all
+}
+
+// overlap returns how much of s is between from and to.
+func overlap(s Session, from, to time.Time) time.Duration {
+	if s.Start.After(from) {
+		from = s.Start
+	}
+	if s.End.Before(to) {
+		to = s.End
+	}
+	if !to.After(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// ComputeStats works out how long the lab was open between from and to,
+// according to history (oldest first). Times are in from's location.
+func ComputeStats(history []Transition, from, to time.Time) Stats {
+	st := Stats{From: from, To: to}
+	all := sessions(history, to)
+
+	for _, s := range all {
+		d := overlap(s, from, to)
+		if d == 0 {
+			continue
+		}
+		st.Open += d
+		st.Sessions++
+
+		if s.Start.Before(from) || s.End.After(to) || s.ongoing {
+			continue
+		}
+		if st.Longest.Duration() == 0 || s.Duration() > st.Longest.Duration() {
+			st.Longest = s
+		}
+		if st.Shortest.Duration() == 0 || s.Duration() < st.Shortest.Duration() {
+			st.Shortest = s
+		}
+	}
+
+	for day := midnight(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		dayFrom, dayTo := day, next
+		if dayFrom.Before(from) {
+			dayFrom = from
+		}
+		if dayTo.After(to) {
+			dayTo = to
+		}
+		ds := DayStats{Day: day}
+		for _, s := range all {
+			ds.Open += overlap(s, dayFrom, dayTo)
+		}
+		st.Days = append(st.Days, ds)
+		st.Weekdays[day.Weekday()] += ds.Open
+	}
+	return st
+}
+
+func formatHours(d time.Duration) string {
+	return fmt.Sprintf("%.1fh", d.Hours())
+}
+
+// FormatStats summarizes st in a few lines, for IRC.
+func FormatStats(st Stats) []string {
+	to := st.To
+	if to.Equal(midnight(to)) {
+		// The period ends at midnight, so the last day is the one before.
+		to = to.Add(-time.Nanosecond)
+	}
+	var lines []string
+
+	line := fmt.Sprintf("%s to %s: open %s, %d times", st.From.Format("Mon Jan 2"), to.Format("Mon Jan 2"), formatHours(st.Open), st.Sessions)
+	if len(st.Days) > 0 {
+		line += fmt.Sprintf(", %s per day on average", formatHours(st.Open/time.Duration(len(st.Days))))
+	}
+	lines = append(lines, line)
+
+	// A week per line, to keep them short.
+	for i := 0; i < len(st.Days); i += 7 {
+		end := i + 7
+		if end > len(st.Days) {
+			end = len(st.Days)
+		}
+		var days []string
+		for _, d := range st.Days[i:end] {
+			days = append(days, fmt.Sprintf("%s %s", d.Day.Format("Mon Jan 2"), formatHours(d.Open)))
+		}
+		lines = append(lines, "Per day: "+strings.Join(days, ", "))
+	}
+
+	var busiest DayStats
+	for _, d := range st.Days {
+		if d.Open > busiest.Open {
+			busiest = d
+		}
+	}
+	var weekdays []string
+	for i := 0; i < 7; i++ {
+		// Monday first.
+		wd := time.Weekday((i + 1) % 7)
+		weekdays = append(weekdays, fmt.Sprintf("%s %s", wd.String()[:3], formatHours(st.Weekdays[wd])))
+	}
+	line = "Per weekday: " + strings.Join(weekdays, ", ")
+	if busiest.Open > 0 {
+		line += fmt.Sprintf(". Busiest day: %s, %s", busiest.Day.Format("Mon Jan 2"), formatHours(busiest.Open))
+	}
+	lines = append(lines, line)
+
+	if st.Longest.Duration() > 0 {
+		loc := st.From.Location()
+		lines = append(lines, fmt.Sprintf("Longest: %s, %s. Shortest: %s, %s",
+			st.Longest.Start.In(loc).Format("Mon Jan 2 15:04"), FormatDuration(st.Longest.Duration()),
+			st.Shortest.Start.In(loc).Format("Mon Jan 2 15:04"), FormatDuration(st.Shortest.Duration())))
+	}
+	return lines
+}
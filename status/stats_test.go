@@ -0,0 +1,81 @@
+package ledsign
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2025, 1, day, hour, min, 0, 0, time.UTC)
+	}
+	history := []Transition{
+		// Began before the period.
+		{Time: at(5, 22, 0), Open: true},
+		{Time: at(6, 2, 0), Open: false},
+		{Time: at(7, 19, 30), Open: true},
+		{Time: at(7, 19, 55), Open: false},
+		{Time: at(11, 13, 0), Open: true},
+		// Eg. a restart without the state file.
+		{Time: at(11, 15, 0), Open: true},
+		{Time: at(11, 21, 5), Open: false},
+		// Still open.
+		{Time: at(12, 23, 0), Open: true},
+	}
+
+	from, to := ReportPeriod("week", at(13, 9, 0))
+	if !from.Equal(at(6, 0, 0)) || !to.Equal(at(13, 0, 0)) {
+		t.Fatalf("ReportPeriod: got %s to %s, want Jan 6 to Jan 13", from, to)
+	}
+	st := ComputeStats(history, from, to)
+
+	if st.Open != 11*time.Hour+30*time.Minute || st.Sessions != 4 {
+		t.Errorf("Open: got %s in %d sessions, want 11h30m in 4", st.Open, st.Sessions)
+	}
+	if len(st.Days) != 7 || st.Days[0].Open != 2*time.Hour || st.Days[6].Open != time.Hour {
+		t.Errorf("Days: got %+v, want 7 days, 2h on the first, 1h on the last", st.Days)
+	}
+	if got, want := st.Longest, (Session{Start: at(11, 13, 0), End: at(11, 21, 5)}); got != want {
+		t.Errorf("Longest: got %+v, want %+v", got, want)
+	}
+	if got, want := st.Shortest, (Session{Start: at(7, 19, 30), End: at(7, 19, 55)}); got != want {
+		t.Errorf("Shortest: got %+v, want %+v", got, want)
+	}
+
+	want := []string{
+		"Mon Jan 6 to Sun Jan 12: open 11.5h, 4 times, 1.6h per day on average",
+		"Per day: Mon Jan 6 2.0h, Tue Jan 7 0.4h, Wed Jan 8 0.0h, Thu Jan 9 0.0h, Fri Jan 10 0.0h, Sat Jan 11 8.1h, Sun Jan 12 1.0h",
+		"Per weekday: Mon 2.0h, Tue 0.4h, Wed 0.0h, Thu 0.0h, Fri 0.0h, Sat 8.1h, Sun 1.0h. Busiest day: Sat Jan 11, 8.1h",
+		"Longest: Sat Jan 11 13:00, 8h5m. Shortest: Tue Jan 7 19:30, 25m",
+	}
+	if got := FormatStats(st); !reflect.DeepEqual(got, want) {
+		t.Errorf("FormatStats: got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestStatsPeriod(t *testing.T) {
+	now := time.Date(2025, 1, 8, 15, 0, 0, 0, time.UTC)
+	from, to := StatsPeriod("week", now)
+	if want := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC); !from.Equal(want) || !to.Equal(now) {
+		t.Errorf("week: got %s to %s, want %s to %s", from, to, want, now)
+	}
+	from, _ = StatsPeriod("month", now)
+	if want := time.Date(2024, 12, 10, 0, 0, 0, 0, time.UTC); !from.Equal(want) {
+		t.Errorf("month: got from %s, want %s", from, want)
+	}
+
+	// No history.
+	from, to = StatsPeriod("week", now)
+	lines := FormatStats(ComputeStats(nil, from, to))
+	if want := "Thu Jan 2 to Wed Jan 8: open 0.0h, 0 times, 0.0h per day on average"; len(lines) != 3 || lines[0] != want {
+		t.Errorf("FormatStats: got %q, want %q, the days and the weekdays", lines, want)
+	}
+
+	// A line per week.
+	from, to = StatsPeriod("month", now)
+	lines = FormatStats(ComputeStats(nil, from, to))
+	if len(lines) != 7 || lines[5] != "Per day: Tue Jan 7 0.0h, Wed Jan 8 0.0h" {
+		t.Errorf("FormatStats month: got %q, want 5 lines per day, the last for Jan 7 and 8", lines)
+	}
+}
@@ -1,10 +1,8 @@
 package ledsign
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"regexp"
@@ -13,176 +11,441 @@ import (
 	"time"
 
 	"foubot2/configuration"
+	"foubot2/httperr"
 	irc "github.com/thoj/go-ircevent"
 
 	"github.com/jonboulle/clockwork"
 	"github.com/mattermost/mattermost-server/v5/model"
-	rpio "github.com/stianeikeland/go-rpio/v4"
 )
 
-const StatusEndPoint = configuration.StatusEndPoint
-const BotChannel = configuration.BotChannel
+// IRC is the part of *irc.Connection used here, so that tests can fake it.
+type IRC interface {
+	Privmsg(target, message string)
+	SendRawf(format string, a ...interface{})
+	AddCallback(eventcode string, callback func(*irc.Event)) int
+}
 
 type SWITCHSTATE struct {
 	ChStop chan struct{}
 	once   sync.Once
+	wg     sync.WaitGroup
 
-	Topic    string
 	calendar Calendar
+	pins     *pinSet
+
+	// Network updates (topic, website, ...), in order for each sink, see
+	// Sink.Name, but concurrently between sinks.
+	sinks workers
+	// Status updates which failed, to try again.
+	retries *retryQueue
+
+	// Hooks, in order, separately so that a slow one doesn't hold up the
+	// network updates. hooksCtx is canceled on close.
+	hooks       *dispatcher
+	hooksCtx    context.Context
+	cancelHooks context.CancelFunc
+
+	mu sync.Mutex
+	// Guarded by mu.
+	Topic string
+	// Lab status (the debounced button, or the override) and when it began,
+	// guarded by mu.
+	status   bool
+	since    time.Time
+	source   string
+	override *Override
+
+	// A reminder that the lab may have been left open is going on, and
+	// which opening was acknowledged with !ack. Guarded by mu.
+	nagging  bool
+	nagAcked time.Time
+
+	// See SetOverride and AckNag.
+	overrides chan *Override
+	acks      chan struct{}
+}
+
+func (ss *SWITCHSTATE) GetSwitchStatus() (status bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.status
+}
+
+// GetSince returns when the current status began.
+func (ss *SWITCHSTATE) GetSince() time.Time {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.since
 }
 
-func GetSwitchStatus() (status bool) {
-	pin := rpio.Pin(23)
-	pin.Input()
-	pin.PullUp()
+// GetTopic returns the IRC topic as last seen or set.
+func (ss *SWITCHSTATE) GetTopic() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.Topic
+}
 
-	return pin.Read() == rpio.High
+// Upcoming returns the calendar events which haven't started yet.
+func (ss *SWITCHSTATE) Upcoming() []Event {
+	return ss.calendar.Upcoming()
 }
 
-func processStatus(ss *SWITCHSTATE, nc *http.Client, irccon *irc.Connection) {
-	var status bool
+// The status in the topic, optionally with the time it began:
+// "|| LAB OPEN ||" or "|| LAB OPEN since 19:42 ||".
+var labStatusRe = regexp.MustCompile(`\|\| LAB ((?:OPEN|CLOSED)(?: since [^|]*?)?) \|\|`)
+
+func processStatus(ss *SWITCHSTATE, nc *http.Client, irccon IRC) {
+	defer ss.wg.Done()
+
+	var status, lastButton bool
+	var since time.Time
 
 	first := true
 
+	// Set with !open or !close; restored from the state file.
+	var override *Override
+	overrideChanged := false
+
+	var saved savedState
+	var haveSaved bool
+	if cfg := configuration.Get(); cfg.StateFile != "" {
+		var err error
+		saved, haveSaved, err = loadState(cfg.StateFile)
+		if err != nil {
+			log.Printf("Load state: %s", err)
+		}
+	}
+	if haveSaved && saved.Override != nil {
+		log.Printf("Manual override restored: %+v", *saved.Override)
+		override = saved.Override
+		overrideChanged = true
+	}
+
+	debouncer := &Debouncer{Name: "Button"}
+
+	// If the button reports edges, react to them right away instead of at the
+	// next poll.
+	button := ss.pins.inputs["button"]
+	edges := button.Edges()
+
+	var bell *doorbell
+	var bellEdges <-chan Edge
+	if pin, ok := ss.pins.inputs["doorbell"]; ok {
+		bell = newDoorbell(pin)
+		bellEdges = pin.Edges()
+		if bellEdges == nil {
+			bellEdges = ss.pollEdges(pin, doorbellPoll)
+		}
+	}
+
 	// If someone changes the topic manually, update our copy.
 	irccon.AddCallback("TOPIC", func(e *irc.Event) {
+		ss.mu.Lock()
 		ss.Topic = e.Arguments[1]
-		log.Printf("Topic updated manually: %s", ss.Topic)
+		ss.mu.Unlock()
+		log.Printf("Topic updated manually: %s", e.Arguments[1])
 	})
 
-OuterLoop:
+	// Inputs without edges are polled.
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+
+	// Fires when a new input level has lasted long enough to be accepted.
+	var confirm <-chan time.Time
+
+	// Reminders already sent, or acknowledged, since the saved status began.
+	// They only count if it goes on.
+	nag := &nagger{}
+	nagChanged := false
+	if haveSaved {
+		nag.since, nag.last = saved.Since, saved.LastNag
+		ss.mu.Lock()
+		if saved.NagAcked {
+			ss.nagAcked = saved.Since
+		} else {
+			ss.nagging = !saved.LastNag.IsZero()
+		}
+		ss.mu.Unlock()
+	}
+
+	// When to check that the Blinker is on or off as it should be.
+	var blinkerCheckAt time.Time
+
+	// The next stats report, checked at every poll.
+	var reportSchedule configuration.Schedule
+	var reportAt time.Time
+
 	for {
-		select {
-		case <-ss.ChStop:
-			break OuterLoop
-
-		case nextEvent := <-ss.calendar.NextEvent:
-			// Escape || to prevent interfering with topic structure.
-			nextEvent = strings.ReplaceAll(nextEvent, "|", ".")
-			if nextEvent == "" {
-				nextEvent = "(none)"
+		cfg := configuration.Get()
+		now := time.Now()
+
+		if bell != nil && bell.update(now, time.Duration(cfg.GPIO.Debounce), time.Duration(cfg.Doorbell.Cooldown)) {
+			text := cfg.Messages.Doorbell.Render(nil)
+			ss.SendMessage(irccon, nc, text)
+			ss.runHooks(cfg, "doorbell", nil)
+			ss.runWebhooks(cfg, nc, "doorbell", "")
+		}
+
+		if cfg.Stats.Schedule != reportSchedule {
+			reportSchedule = cfg.Stats.Schedule
+			reportAt = reportSchedule.Next(now)
+		}
+		if !reportAt.IsZero() && !now.Before(reportAt) {
+			reportAt = reportSchedule.Next(now)
+			ss.sinks.run("stats", func() {
+				ss.sendStats(cfg, irccon, nc, now)
+			})
+		}
+
+		debouncer.Hold = time.Duration(cfg.GPIO.Debounce)
+		buttonStatus := debouncer.Update(button.Active(), now)
+		if override != nil && !first && buttonStatus != lastButton {
+			log.Printf("Button changed, manual override ended")
+			override = nil
+			overrideChanged = true
+		}
+		lastButton = buttonStatus
+		if override != nil && override.expired(now) {
+			log.Printf("Manual override expired")
+			override = nil
+			overrideChanged = true
+		}
+
+		newStatus, source := buttonStatus, "button"
+		if override != nil {
+			newStatus, source = override.Open, "override"
+		}
+		changed := false
+		if first || status != newStatus {
+			log.Printf("New status: %v (%s)\n", newStatus, source)
+			status = newStatus
+
+			change := StatusChange{
+				Open:    status,
+				Since:   now,
+				Source:  source,
+				Startup: first,
+				Changed: true,
+			}
+			if first && haveSaved && saved.Open == status {
+				log.Printf("Status unchanged since %s (before restart)", saved.Since.Format(time.RFC3339))
+				change.Since = saved.Since
+				change.Changed = false
+			}
+			since = change.Since
+			changed = change.Changed
+			if change.Changed {
+				labTransitions.Inc(statusLabel(change.Open), source)
+			}
+			if change.Changed && cfg.HistoryFile != "" {
+				err := appendHistory(cfg.HistoryFile, Transition{Time: change.Since, Open: change.Open, Source: source})
+				if err != nil {
+					log.Printf("Append history: %s", err)
+				}
 			}
 
-			ss.UpdateTopic(irccon, nc, regexp.MustCompile(`\|\| Next event: (.*?) \|\|`), nextEvent)
+			ss.changeStatus(cfg, irccon, nc, change)
+		}
+		if overrideChanged {
+			ss.mu.Lock()
+			ss.override = override
+			ss.mu.Unlock()
+		}
 
-		case startingEvent := <-ss.calendar.StartingEvent:
-			ss.SendMessage(irccon, nc, fmt.Sprintf("Starting event: %s", startingEvent))
+		// After changeStatus, so that a pending status is merged into the new
+		// one instead of being delivered ahead of it. The first changeStatus
+		// takes everything pending from before a restart.
+		if !first {
+			ss.retry(cfg, irccon, nc, now)
+		}
 
-		default:
-			newStatus := GetSwitchStatus()
-			if first || status != newStatus {
-				log.Printf("New status: %v\n", newStatus)
-				status = newStatus
+		if cfg.Blinker.Check > 0 && !now.Before(blinkerCheckAt) {
+			blinkerCheckAt = now.Add(time.Duration(cfg.Blinker.Check))
+			ss.checkBlinker(cfg, nc, status)
+		}
 
-				var strStatus string
-				var cmnd string
-				if status {
-					strStatus = "OPEN"
-					cmnd = "On"
-				} else {
-					strStatus = "CLOSED"
-					cmnd = "off"
-				}
+		if status && nag.due(cfg.Nag, since, now) && ss.startNag(since) {
+			text := cfg.Messages.Nag.Render(map[string]string{
+				"Since":    FormatSince(since, now),
+				"Duration": FormatDuration(now.Sub(since)),
+			})
+			ss.SendMessage(irccon, nc, text)
+			nagChanged = true
+		}
 
-				// IRC, Mattermost
-				ss.UpdateTopic(irccon, nc, regexp.MustCompile(`\|\| LAB (OPEN|CLOSED) \|\|`), strStatus)
+		if (changed || overrideChanged || nagChanged) && cfg.StateFile != "" {
+			state := savedState{Open: status, Since: since, Override: override}
+			if nag.since.Equal(since) {
+				state.LastNag = nag.last
+			}
+			ss.mu.Lock()
+			state.NagAcked = ss.nagAcked.Equal(since)
+			ss.mu.Unlock()
+			if err := saveState(cfg.StateFile, state); err != nil {
+				log.Printf("Save state: %s", err)
+			}
+		}
+		overrideChanged = false
+		nagChanged = false
+		first = false
+
+		confirm = nil
+		var deadline time.Time
+		debouncers := []*Debouncer{debouncer}
+		if bell != nil {
+			debouncers = append(debouncers, &bell.debouncer)
+		}
+		for _, d := range debouncers {
+			if t, ok := d.Deadline(); ok && (deadline.IsZero() || t.Before(deadline)) {
+				deadline = t
+			}
+		}
+		if !deadline.IsZero() {
+			confirm = time.After(time.Until(deadline))
+		}
 
-				// IRC announcement (but not at startup, to avoid spam)
-				if !first && configuration.TopicSendToChannel {
-					irccon.Privmsg(BotChannel, fmt.Sprintf("|| LAB %s ||", strStatus))
+		// Wait for something to happen. Calendar updates don't touch the inputs,
+		// so they don't need to go around the loop.
+	Wait:
+		for {
+			select {
+			case <-ss.ChStop:
+				return
+
+			case nextEvent := <-ss.calendar.NextEvent:
+				// Escape || to prevent interfering with topic structure.
+				nextEvent = strings.ReplaceAll(nextEvent, "|", ".")
+				if nextEvent == "" {
+					nextEvent = "(none)"
 				}
 
-				// GPIO
-				pin := rpio.Pin(24)
-				pin.Output()
-				if status {
-					pin.High()
+				ss.UpdateTopic(irccon, nc, regexp.MustCompile(`\|\| Next event: (.*?) \|\|`), nextEvent)
+
+			case startingEvent := <-ss.calendar.StartingEvent:
+				cfg := configuration.Get()
+				sinks := ss.newSinks(cfg, irccon, nc)
+				ss.notify(sinks, func(s Sink) (string, error) {
+					return s.OnEventStarting(startingEvent)
+				})
+				ss.runHooks(cfg, "event_start", map[string]string{
+					"FOUBOT2_CALENDAR_EVENT": startingEvent,
+				})
+				ss.runWebhooks(cfg, nc, "event_start", startingEvent)
+
+			case o := <-ss.overrides:
+				log.Printf("Manual override: %+v", o)
+				override = o
+				overrideChanged = true
+				break Wait
+
+			case <-ss.acks:
+				nagChanged = true
+				break Wait
+
+			// The new level counts from the time of the edge (from the kernel,
+			// with cdev), not from when it is read here.
+			case e, ok := <-edges:
+				if !ok {
+					edges = nil
 				} else {
-					pin.Low()
+					debouncer.Update(button.activeAfter(e), e.Time)
 				}
-
-				// For downstairs "Open" LED
-				highPin := rpio.Pin(17)
-				highPin.Output()
-				if status {
-					highPin.High()
+				break Wait
+			case e, ok := <-bellEdges:
+				if !ok {
+					bellEdges = nil
 				} else {
-					highPin.Low()
+					bell.debouncer.Update(bell.pin.activeAfter(e), e.Time)
 				}
+				break Wait
+			case <-confirm:
+				break Wait
+			case <-poll.C:
+				break Wait
+			}
+		}
+	}
+}
 
-				var resp *http.Response
-				var err error
-
-				// Website
-				if StatusEndPoint != "" {
-					resp, err = nc.Get(StatusEndPoint + strStatus)
-					if err != nil {
-						log.Printf("StatusEndPoint error: %s\n", err)
-					} else {
-						log.Printf("StatusEndPoint: %s", resp.Status)
-						io.Copy(ioutil.Discard, resp.Body)
-						resp.Body.Close()
-					}
-				}
+// changeStatus drives the outputs for a new lab status. GPIO is set right
+// away; the sinks are updated on their own workers, see notify.
+func (ss *SWITCHSTATE) changeStatus(cfg *configuration.Config, irccon IRC, nc *http.Client, change StatusChange) {
+	ss.mu.Lock()
+	ss.status = change.Open
+	ss.since = change.Since
+	ss.source = change.Source
+	if change.Changed {
+		ss.nagging = false
+	}
+	ss.mu.Unlock()
+	if change.Open {
+		labOpen.Set(1)
+	} else {
+		labOpen.Set(0)
+	}
 
-				// Blinker
-				if configuration.Blinker != "" {
-					resp, err = nc.Get(configuration.Blinker + "cm?cmnd=Power%20" + cmnd)
-					if err != nil {
-						log.Printf("Blinker error: %s\n", err)
-					} else {
-						log.Printf("Blinker: %s", resp.Status)
-						io.Copy(ioutil.Discard, resp.Body)
-						resp.Body.Close()
-					}
-				}
+	// GPIO
+	for _, o := range ss.pins.followOpen {
+		o.SetActive(change.Open)
+	}
 
-				// Melody
-				if strStatus == "CLOSED" {
-					data := bytes.NewBufferString(`{"jsonrpc": "2.0", "id": 1, "method": "core.playback.stop"}`)
-					resp, err = nc.Post("http://melody/mopidy/rpc", "application/json", data)
-					if err != nil {
-						log.Printf("Melody error: %s\n", err)
-					} else {
-						log.Printf("Melody: %s", resp.Status)
-						io.Copy(ioutil.Discard, resp.Body)
-						resp.Body.Close()
-					}
-				}
-			}
-			first = false
-			time.Sleep(time.Second)
-		}
+	if change.Changed {
+		ss.runHooks(cfg, strings.ToLower(statusString(change.Open)), nil)
+	}
+
+	sinks := ss.newSinks(cfg, irccon, nc)
+	ss.notify(sinks, func(s Sink) (string, error) {
+		return ss.deliver(cfg, s, &change)
+	})
+
+	if change.Changed {
+		ss.runWebhooks(cfg, nc, strings.ToLower(statusString(change.Open)), "")
 	}
 }
 
 // UpdateTopic modifies the topic (IRC, Mattermost) by matching `re` and replacing
 // the subexpression by `new`. The regexp must have exactly one subexpression.
-func (ss *SWITCHSTATE) UpdateTopic(irccon *irc.Connection, nc *http.Client, re *regexp.Regexp, new string) {
-	err := ss.updateTopicIRC(irccon, re, new)
-	if err != nil {
-		log.Printf("updateTopicIRC error: %s\n", err)
+// The updates are queued on the workers of the irc and mattermost sinks.
+func (ss *SWITCHSTATE) UpdateTopic(irccon IRC, nc *http.Client, re *regexp.Regexp, new string) {
+	cfg := configuration.Get()
+	if cfg.SinkEnabled("irc") {
+		ss.sinks.run("irc", func() {
+			start := time.Now()
+			err := ss.updateTopicIRC(cfg, irccon, re, new)
+			if err != nil {
+				log.Printf("updateTopicIRC error: %s\n", err)
+			}
+			recordResult("irc", start, "", err)
+		})
 	}
 
-	if configuration.MattermostServer != "" {
-		err = ss.updateTopicMattermost(nc, re, new)
-		if err != nil {
-			log.Printf("updateTopicMattermost error: %s\n", err)
-		}
+	if cfg.Mattermost.Server != "" && cfg.SinkEnabled("mattermost") {
+		nc := sinkClient(cfg, nc, "mattermost")
+		ss.sinks.run("mattermost", func() {
+			start := time.Now()
+			err := ss.updateTopicMattermost(cfg, nc, re, new)
+			if err != nil {
+				log.Printf("updateTopicMattermost error: %s\n", err)
+			}
+			recordResult("mattermost", start, "", err)
+		})
 	}
 }
 
-func (ss *SWITCHSTATE) updateTopicIRC(irccon *irc.Connection, re *regexp.Regexp, new string) error {
+func (ss *SWITCHSTATE) updateTopicIRC(cfg *configuration.Config, irccon IRC, re *regexp.Regexp, new string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
 	match := re.FindStringSubmatchIndex(ss.Topic)
 	if len(match) == 4 {
 		start, end := match[2], match[3]
 		topic := ss.Topic[:start] + new + ss.Topic[end:]
 		if ss.Topic != topic {
 			log.Printf("New IRC topic: %q\n", topic)
-			if configuration.TopicUseChanserv {
-				irccon.Privmsg("ChanServ", fmt.Sprintf("TOPIC %s %s", configuration.BotChannel, topic))
+			if cfg.Topic.UseChanserv {
+				irccon.Privmsg("ChanServ", fmt.Sprintf("TOPIC %s %s", cfg.IRC.Channel, topic))
 			} else {
-				irccon.SendRawf("TOPIC %s :%s", BotChannel, topic)
+				irccon.SendRawf("TOPIC %s :%s", cfg.IRC.Channel, topic)
 			}
 			ss.Topic = topic
 		} else {
@@ -194,68 +457,118 @@ func (ss *SWITCHSTATE) updateTopicIRC(irccon *irc.Connection, re *regexp.Regexp,
 	return nil
 }
 
-func (ss *SWITCHSTATE) updateTopicMattermost(nc *http.Client, re *regexp.Regexp, new string) error {
-	mm := model.NewAPIv4Client(configuration.MattermostServer)
+func (ss *SWITCHSTATE) updateTopicMattermost(cfg *configuration.Config, nc *http.Client, re *regexp.Regexp, new string) error {
+	mm := model.NewAPIv4Client(cfg.Mattermost.Server)
 	mm.HttpClient = nc
-	mm.SetToken(configuration.MattermostToken)
+	mm.SetToken(cfg.Mattermost.Token.Value())
 
-	channel, resp := mm.GetChannel(configuration.MattermostChannelId, "")
+	channel, resp := mm.GetChannel(cfg.Mattermost.ChannelID, "")
 	if channel == nil {
-		log.Printf("Mattermost error: Get channel: %+v\n", resp)
-	} else {
-		match := re.FindStringSubmatchIndex(channel.Header)
-		if len(match) == 4 {
-			start, end := match[2], match[3]
-			header := channel.Header[:start] + new + channel.Header[end:]
+		return mattermostError("Get channel", resp)
+	}
 
-			if header != channel.Header {
-				log.Printf("New Mattermost header: %q\n", header)
+	match := re.FindStringSubmatchIndex(channel.Header)
+	if len(match) == 4 {
+		start, end := match[2], match[3]
+		header := channel.Header[:start] + new + channel.Header[end:]
 
-				updated, resp := mm.PatchChannel(channel.Id, &model.ChannelPatch{
-					Header: &header,
-				})
-				if updated == nil {
-					return fmt.Errorf("Patch channel error: %+v", resp)
-				}
-			} else {
-				log.Printf("Mattermost header unchanged\n")
+		if header != channel.Header {
+			log.Printf("New Mattermost header: %q\n", header)
+
+			updated, resp := mm.PatchChannel(channel.Id, &model.ChannelPatch{
+				Header: &header,
+			})
+			if updated == nil {
+				return mattermostError("Patch channel error", resp)
 			}
 		} else {
-			return fmt.Errorf("Mattermost header %q did not match regexp: %q", channel.Header, re)
+			log.Printf("Mattermost header unchanged\n")
 		}
+	} else {
+		return fmt.Errorf("Mattermost header %q did not match regexp: %q", channel.Header, re)
 	}
 	return nil
 }
 
-func (ss *SWITCHSTATE) SendMessage(irccon *irc.Connection, nc *http.Client, text string) {
+// SendMessage posts text to IRC right away, and queues it for Mattermost.
+func (ss *SWITCHSTATE) SendMessage(irccon IRC, nc *http.Client, text string) {
+	cfg := configuration.Get()
+
 	// IRC
-	irccon.Privmsg(BotChannel, text)
+	irccon.Privmsg(cfg.IRC.Channel, text)
 
 	// Mattermost
-	if configuration.MattermostServer != "" {
-		mm := model.NewAPIv4Client(configuration.MattermostServer)
-		mm.HttpClient = nc
-		mm.SetToken(configuration.MattermostToken)
-
-		post := &model.Post{
-			ChannelId: configuration.MattermostChannelId,
-			Message:   text,
-		}
-		post, resp := mm.CreatePost(post)
-		if post == nil {
-			log.Printf("Create post error: %+v", resp)
-		}
+	if cfg.Mattermost.Server != "" && cfg.SinkEnabled("mattermost") {
+		nc := sinkClient(cfg, nc, "mattermost")
+		ss.sinks.run("mattermost", func() {
+			start := time.Now()
+			err := postMattermost(cfg, nc, text)
+			if err != nil {
+				log.Printf("Mattermost error: %s", err)
+			}
+			recordResult("mattermost", start, "", err)
+		})
+	}
+}
+
+func postMattermost(cfg *configuration.Config, nc *http.Client, text string) error {
+	mm := model.NewAPIv4Client(cfg.Mattermost.Server)
+	mm.HttpClient = nc
+	mm.SetToken(cfg.Mattermost.Token.Value())
+
+	post, resp := mm.CreatePost(&model.Post{
+		ChannelId: cfg.Mattermost.ChannelID,
+		Message:   text,
+	})
+	if post == nil {
+		return mattermostError("Create post", resp)
+	}
+	return nil
+}
+
+// mattermostError describes a failed API call, see transient.
+func mattermostError(what string, resp *model.Response) error {
+	return &httperr.StatusError{Code: resp.StatusCode, Text: fmt.Sprintf("%s: %+v", what, resp)}
+}
+
+// sendStats posts the scheduled stats report, for the period ending at the
+// last midnight.
+func (ss *SWITCHSTATE) sendStats(cfg *configuration.Config, irccon IRC, nc *http.Client, now time.Time) {
+	if cfg.HistoryFile == "" {
+		log.Printf("Stats report: history_file is not set")
+		return
+	}
+	history, err := ReadHistory(cfg.HistoryFile)
+	if err != nil {
+		log.Printf("Stats report: %s", err)
+		return
+	}
+	from, to := ReportPeriod(cfg.Stats.Period, now)
+	lines := FormatStats(ComputeStats(history, from, to))
+	lines[0] = "Lab stats, " + lines[0]
+	for _, line := range lines {
+		ss.SendMessage(irccon, nc, line)
 	}
 }
 
+// Reload applies the settings of cfg which are not read afresh for every
+// update. cfg must already be in effect (configuration.Set).
+func (ss *SWITCHSTATE) Reload(cfg *configuration.Config) {
+	ss.calendar.Reload(cfg.Calendar.URL, time.Duration(cfg.Calendar.Interval))
+}
+
 func (ss *SWITCHSTATE) CloseSwitchStatus() {
 	ss.once.Do(func() {
 		ss.calendar.Close()
 		close(ss.ChStop)
+		ss.wg.Wait()
+		ss.cancelHooks()
+		ss.hooks.close()
+		ss.sinks.close()
 	})
 }
 
-func NewSwitchStatus(topic string, irccon *irc.Connection) *SWITCHSTATE {
+func NewSwitchStatus(topic string, irccon IRC, gpio GPIO) (*SWITCHSTATE, error) {
 	chStop := make(chan struct{})
 
 	netTransport := &http.Transport{
@@ -269,29 +582,42 @@ func NewSwitchStatus(topic string, irccon *irc.Connection) *SWITCHSTATE {
 		Timeout:   10 * time.Second,
 	}
 
+	cfg := configuration.Get()
+
 	switchInstance := &SWITCHSTATE{
-		Topic:  topic,
-		ChStop: chStop,
+		Topic:     topic,
+		ChStop:    chStop,
+		overrides: make(chan *Override),
+		acks:      make(chan struct{}, 1),
 		calendar: Calendar{
 			Clock:       clockwork.NewRealClock(),
 			HTTPClient:  netClient,
-			URL:         configuration.CalendarURL,
-			GetInterval: 60 * time.Minute,
+			URL:         cfg.Calendar.URL,
+			GetInterval: time.Duration(cfg.Calendar.Interval),
 		},
 	}
 
-	if err := rpio.Open(); err != nil {
-		panic(err)
+	pins, err := setupPins(gpio, cfg.GPIO.Pins)
+	if err != nil {
+		return nil, err
 	}
+	switchInstance.pins = pins
+	// Until processStatus reads the button (and the saved state).
+	switchInstance.status = pins.inputs["button"].Active()
+	switchInstance.since = time.Now()
+	switchInstance.source = "button"
 
-	// For downstairs "Open" LED
-	gndPin := rpio.Pin(21)
-	gndPin.Output()
-	gndPin.Low()
-
+	retries, err := loadRetries(cfg.Retry.File)
+	if err != nil {
+		log.Printf("Load retries: %s", err)
+	}
+	switchInstance.retries = retries
+	switchInstance.hooks = newDispatcher()
+	switchInstance.hooksCtx, switchInstance.cancelHooks = context.WithCancel(context.Background())
 	switchInstance.calendar.Start()
 
+	switchInstance.wg.Add(1)
 	go processStatus(switchInstance, netClient, irccon)
 
-	return switchInstance
+	return switchInstance, nil
 }
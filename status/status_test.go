@@ -0,0 +1,549 @@
+package ledsign
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"foubot2/configuration"
+	"foubot2/metrics"
+	irc "github.com/thoj/go-ircevent"
+)
+
+type fakeIRC struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (f *fakeIRC) Privmsg(target, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, fmt.Sprintf("%s: %s", target, message))
+}
+
+func (f *fakeIRC) SendRawf(format string, a ...interface{}) {
+	f.Privmsg("(raw)", fmt.Sprintf(format, a...))
+}
+
+func (f *fakeIRC) AddCallback(eventcode string, callback func(*irc.Event)) int {
+	return 0
+}
+
+// waitFor polls cond until it returns true, or fails the test.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *fakeIRC) sent(message string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.messages {
+		if m == message {
+			return true
+		}
+	}
+	return false
+}
+
+// withTestConfig puts config in effect for the duration of the test, with an
+// empty calendar, temporary state, history and retry files, and no melody
+// unless config sets its own.
+func withTestConfig(t *testing.T, config string) (stateFile string) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "BEGIN:VCALENDAR\nEND:VCALENDAR\n")
+	}))
+	t.Cleanup(hs.Close)
+
+	old := configuration.Get()
+	t.Cleanup(func() { configuration.Set(old) })
+
+	cfg, err := configuration.Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Calendar.URL = hs.URL
+	if cfg.Melody.URL == configuration.Default().Melody.URL {
+		cfg.Melody.URL = ""
+	}
+	dir := t.TempDir()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	cfg.HistoryFile = filepath.Join(dir, "history.jsonl")
+	cfg.Retry.File = filepath.Join(dir, "retries.json")
+	configuration.Set(cfg)
+	return cfg.StateFile
+}
+
+// startOpen starts the status with the lab closed in the topic, and waits
+// until the button, held down, opens it.
+func startOpen(t *testing.T, gpio GPIO) (*SWITCHSTATE, *fakeIRC) {
+	t.Helper()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	t.Cleanup(ss.CloseSwitchStatus)
+
+	waitFor(t, "topic OPEN", func() bool {
+		return fi.sent("ChanServ: TOPIC #foulab Foulab || LAB OPEN || Next event: (none) ||")
+	})
+	return ss, fi
+}
+
+func TestSwitchStatusFakeGPIO(t *testing.T) {
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake"}}`)
+
+	gpio := NewFakeGPIO()
+	gpio.SetInput(23, true)
+	ss, fi := startOpen(t, gpio)
+	for _, pin := range []int{24, 17} {
+		if high, ok := gpio.Level(pin); !ok || !high {
+			t.Errorf("Pin %d: got high=%v ok=%v, want high", pin, high, ok)
+		}
+	}
+	if high, ok := gpio.Level(21); !ok || high {
+		t.Errorf("Pin 21: got high=%v ok=%v, want low", high, ok)
+	}
+
+	gpio.SetInput(23, false)
+	waitFor(t, "topic CLOSED", func() bool {
+		return fi.sent("ChanServ: TOPIC #foulab Foulab || LAB CLOSED || Next event: (none) ||")
+	})
+	for _, pin := range []int{24, 17} {
+		if high, _ := gpio.Level(pin); high {
+			t.Errorf("Pin %d: got high, want low", pin)
+		}
+	}
+
+	if ss.GetSwitchStatus() {
+		t.Errorf("GetSwitchStatus: got true, want false")
+	}
+}
+
+func TestEdgeTime(t *testing.T) {
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "1h"}}`)
+
+	gpio := NewFakeGPIO()
+	_, fi := startOpen(t, gpio)
+
+	// Released two hours ago, as timestamped by the kernel: that is longer
+	// than gpio.debounce already.
+	gpio.mu.Lock()
+	p := gpio.pin(23)
+	p.level, p.levelSet = false, true
+	p.edges <- Edge{Time: time.Now().Add(-2 * time.Hour), Rising: false}
+	gpio.mu.Unlock()
+
+	waitFor(t, "topic CLOSED", func() bool {
+		return fi.sent("ChanServ: TOPIC #foulab Foulab || LAB CLOSED || Next event: (none) ||")
+	})
+}
+
+func TestTopicShowSince(t *testing.T) {
+	stateFile := withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake"}, "topic": {"use_chanserv": true, "show_since": true}}`)
+	since := time.Now().Add(-time.Hour)
+	if err := saveState(stateFile, savedState{Open: true, Since: since}); err != nil {
+		t.Fatal(err)
+	}
+
+	gpio := NewFakeGPIO()
+	gpio.SetInput(23, true)
+	fi := &fakeIRC{}
+
+	// The old format is replaced as well.
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	want := "ChanServ: TOPIC #foulab Foulab || LAB OPEN since " + FormatSince(since, time.Now()) + " || Next event: (none) ||"
+	waitFor(t, "topic OPEN since", func() bool {
+		return fi.sent(want)
+	})
+	if got := ss.GetSince(); !got.Equal(since) {
+		t.Errorf("GetSince: got %s, want %s", got, since)
+	}
+
+	gpio.SetInput(23, false)
+	waitFor(t, "topic CLOSED since", func() bool {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		return labStatusRe.FindStringSubmatch(ss.Topic)[1] == "CLOSED since "+FormatSince(ss.since, time.Now())
+	})
+}
+
+func TestSwitchStatusPinMap(t *testing.T) {
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "pins": {
+		"button": {"pin": 5, "direction": "input", "pull": "down", "active_low": true},
+		"green":  {"pin": 6, "direction": "output", "follows": "open"},
+		"red":    {"pin": 7, "direction": "output", "follows": "open", "active_low": true},
+		"power":  {"pin": 8, "direction": "output", "initial": true}
+	}}}`)
+
+	gpio := NewFakeGPIO()
+	// Active low: pressed.
+	gpio.SetInput(5, false)
+	startOpen(t, gpio)
+	for _, tc := range []struct {
+		pin  int
+		want bool
+	}{
+		{6, true},
+		{7, false},
+		{8, true},
+	} {
+		if high, ok := gpio.Level(tc.pin); !ok || high != tc.want {
+			t.Errorf("Pin %d: got high=%v ok=%v, want high=%v", tc.pin, high, ok, tc.want)
+		}
+	}
+	// Not in the pin map.
+	if _, ok := gpio.Level(24); ok {
+		t.Errorf("Pin 24: configured as output, want unused")
+	}
+}
+
+func (f *fakeIRC) count(message string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, m := range f.messages {
+		if m == message {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDoorbell(t *testing.T) {
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "10ms", "pins": {
+		"button":   {"pin": 23, "direction": "input", "pull": "up"},
+		"doorbell": {"pin": 4, "direction": "input", "pull": "up", "active_low": true}
+	}}, "doorbell": {"cooldown": "1h"}}`)
+
+	gpio := NewFakeGPIO()
+	_, fi := startOpen(t, gpio)
+
+	press := func() {
+		gpio.SetInput(4, false)
+		time.Sleep(100 * time.Millisecond)
+		gpio.SetInput(4, true)
+		time.Sleep(100 * time.Millisecond)
+	}
+	press()
+	waitFor(t, "doorbell announcement", func() bool {
+		return fi.sent("#foulab: Someone is at the door")
+	})
+
+	// Within the cooldown.
+	press()
+	time.Sleep(time.Second)
+	if n := fi.count("#foulab: Someone is at the door"); n != 1 {
+		t.Errorf("Doorbell announcements: got %d, want 1", n)
+	}
+}
+
+// pollingGPIO is a FakeGPIO whose inputs don't report edges, like go-rpio.
+type pollingGPIO struct {
+	*FakeGPIO
+}
+
+func (g pollingGPIO) Input(n int, pull Pull) (InputPin, error) {
+	pin, err := g.FakeGPIO.Input(n, pull)
+	return struct{ InputPin }{pin}, err
+}
+
+func TestDoorbellPolled(t *testing.T) {
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "10ms", "pins": {
+		"button":   {"pin": 23, "direction": "input", "pull": "up"},
+		"doorbell": {"pin": 4, "direction": "input", "pull": "up", "active_low": true}
+	}}}`)
+
+	gpio := NewFakeGPIO()
+	_, fi := startOpen(t, pollingGPIO{gpio})
+
+	// Well under the 1s poll of the button.
+	gpio.SetInput(4, false)
+	time.Sleep(100 * time.Millisecond)
+	gpio.SetInput(4, true)
+	waitFor(t, "doorbell announcement", func() bool {
+		return fi.sent("#foulab: Someone is at the door")
+	})
+}
+
+func TestSlowSinkDoesNotBlockGPIO(t *testing.T) {
+	hung := make(chan struct{})
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer hs.Close()
+
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "0s"}, "status_endpoint": "`+hs.URL+`/"}`)
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+	defer close(hung)
+
+	// The website update for OPEN hangs; the LED must still follow the button.
+	for _, level := range []bool{false, true, false} {
+		gpio.SetInput(23, level)
+		waitFor(t, fmt.Sprintf("pin 24 %v", level), func() bool {
+			high, _ := gpio.Level(24)
+			return high == level
+		})
+	}
+}
+
+func TestSavedState(t *testing.T) {
+	var mu sync.Mutex
+	var hits []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits = append(hits, r.URL.Path)
+	}))
+	defer hs.Close()
+	getHits := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), hits...)
+	}
+
+	stateFile := withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "0s"}, "status_endpoint": "`+hs.URL+`/"}`)
+	since := time.Date(2025, 1, 2, 19, 42, 0, 0, time.UTC)
+	if err := saveState(stateFile, savedState{Open: true, Since: since}); err != nil {
+		t.Fatal(err)
+	}
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB OPEN || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	// Still open: GPIO set, website not notified again.
+	waitFor(t, "pin 24 high", func() bool {
+		high, _ := gpio.Level(24)
+		return high
+	})
+	time.Sleep(100 * time.Millisecond)
+	if got := getHits(); len(got) != 0 {
+		t.Errorf("Website requests: got %q, want none", got)
+	}
+
+	gpio.SetInput(23, false)
+	waitFor(t, "website CLOSED", func() bool {
+		got := getHits()
+		return len(got) == 1 && got[0] == "/CLOSED"
+	})
+	var website SinkResult
+	for _, r := range LastResults() {
+		if r.Sink == "website" {
+			website = r
+		}
+	}
+	if website.Err != nil || website.Detail != "200 OK" {
+		t.Errorf("Website result: got %+v, want 200 OK", website)
+	}
+	var b strings.Builder
+	metrics.Write(&b)
+	for _, want := range []string{
+		"foubot2_lab_open 0\n",
+		"foubot2_lab_transitions_total{status=\"closed\",source=\"button\"} ",
+		"foubot2_sink_requests_total{sink=\"website\"} ",
+		"foubot2_calendar_fetches_total{result=\"ok\"} ",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Metrics: missing %q", want)
+		}
+	}
+
+	saved, ok, err := loadState(stateFile)
+	if err != nil || !ok {
+		t.Fatalf("loadState: got ok=%v err=%v", ok, err)
+	}
+	if saved.Open || !saved.Since.After(since) {
+		t.Errorf("Saved state: got %+v, want closed since now", saved)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	stateFile := withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "0s"}}`)
+
+	gpio := NewFakeGPIO()
+	ss, fi := startOpen(t, gpio)
+
+	// Closed by hand, like a button press.
+	ss.SetOverride(&Override{Open: false, By: "alice"})
+	waitFor(t, "topic CLOSED", func() bool {
+		return fi.sent("ChanServ: TOPIC #foulab Foulab || LAB CLOSED || Next event: (none) ||")
+	})
+	if high, _ := gpio.Level(24); high {
+		t.Errorf("Pin 24: got high, want low")
+	}
+	if o := ss.GetOverride(); o == nil || o.By != "alice" {
+		t.Errorf("GetOverride: got %+v, want by alice", o)
+	}
+	waitFor(t, "override saved", func() bool {
+		saved, _, _ := loadState(stateFile)
+		return saved.Override != nil && !saved.Open
+	})
+	history, err := ReadHistory(configuration.Get().HistoryFile)
+	if err != nil || len(history) != 2 || history[1].Source != "override" || history[1].Open {
+		t.Errorf("History: got %+v (%v), want closed by override last", history, err)
+	}
+
+	// Back to the button.
+	ss.SetOverride(nil)
+	waitFor(t, "status OPEN", func() bool {
+		return ss.GetSwitchStatus() && ss.GetOverride() == nil
+	})
+
+	// Expires.
+	ss.SetOverride(&Override{Open: false, Until: time.Now().Add(500 * time.Millisecond), By: "alice"})
+	waitFor(t, "status CLOSED", func() bool {
+		return !ss.GetSwitchStatus()
+	})
+	waitFor(t, "override expired", func() bool {
+		return ss.GetSwitchStatus() && ss.GetOverride() == nil
+	})
+
+	// Ended by the button.
+	ss.SetOverride(&Override{Open: true, By: "alice"})
+	waitFor(t, "override", func() bool {
+		return ss.GetOverride() != nil
+	})
+	gpio.SetInput(23, false)
+	waitFor(t, "override ended by the button", func() bool {
+		return !ss.GetSwitchStatus() && ss.GetOverride() == nil
+	})
+}
+
+func TestOverrideRestored(t *testing.T) {
+	stateFile := withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake"}}`)
+	since := time.Now().Add(-time.Hour)
+	err := saveState(stateFile, savedState{Open: false, Since: since, Override: &Override{Open: false, By: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The button says open, the override wins.
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB CLOSED || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	waitFor(t, "override restored", func() bool {
+		return ss.GetOverride() != nil
+	})
+	if ss.GetSwitchStatus() || !ss.GetSince().Equal(since) {
+		t.Errorf("Status: got open=%v since %s, want closed since %s", ss.GetSwitchStatus(), ss.GetSince(), since)
+	}
+}
+
+func TestNag(t *testing.T) {
+	stateFile := withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake"}, "nag": {"open_for": "8h", "repeat": "1h"},
+		"messages": {"nag": "Still open for {{.Duration}}"}}`)
+	err := saveState(stateFile, savedState{Open: true, Since: time.Now().Add(-9 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpio := NewFakeGPIO()
+	fi := &fakeIRC{}
+	ss, err := NewSwitchStatus("Foulab || LAB OPEN || Next event: (none) ||", fi, gpio)
+	if err != nil {
+		t.Fatalf("NewSwitchStatus: %s", err)
+	}
+	defer ss.CloseSwitchStatus()
+
+	waitFor(t, "reminder", func() bool {
+		return fi.sent("#foulab: Still open for 9h0m")
+	})
+	if !ss.AckNag() {
+		t.Errorf("AckNag: got false, want true")
+	}
+	if ss.AckNag() {
+		t.Errorf("AckNag again: got true, want false")
+	}
+}
+
+func TestNagReconnect(t *testing.T) {
+	stateFile := withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake"}, "nag": {"open_for": "8h", "repeat": "1h"},
+		"messages": {"nag": "Still open for {{.Duration}}"}}`)
+	err := saveState(stateFile, savedState{Open: true, Since: time.Now().Add(-9 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpio := NewFakeGPIO()
+	connect := func() (*SWITCHSTATE, *fakeIRC) {
+		fi := &fakeIRC{}
+		ss, err := NewSwitchStatus("Foulab || LAB OPEN || Next event: (none) ||", fi, gpio)
+		if err != nil {
+			t.Fatalf("NewSwitchStatus: %s", err)
+		}
+		return ss, fi
+	}
+	nagged := func(fi *fakeIRC) bool {
+		fi.mu.Lock()
+		defer fi.mu.Unlock()
+		for _, m := range fi.messages {
+			if strings.HasPrefix(m, "#foulab: Still open") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ss, fi := connect()
+	waitFor(t, "reminder", func() bool { return nagged(fi) })
+	waitFor(t, "reminder saved", func() bool {
+		s, _, _ := loadState(stateFile)
+		return !s.LastNag.IsZero()
+	})
+	ss.CloseSwitchStatus()
+
+	// Not reminded again before nag.repeat, but it can still be acknowledged.
+	ss, fi = connect()
+	time.Sleep(200 * time.Millisecond)
+	if nagged(fi) {
+		t.Errorf("Reminded again right after reconnecting")
+	}
+	if !ss.AckNag() {
+		t.Errorf("AckNag after reconnecting: got false, want true")
+	}
+	waitFor(t, "ack saved", func() bool {
+		s, _, _ := loadState(stateFile)
+		return s.NagAcked
+	})
+	ss.CloseSwitchStatus()
+
+	ss, fi = connect()
+	defer ss.CloseSwitchStatus()
+	time.Sleep(200 * time.Millisecond)
+	if nagged(fi) || ss.AckNag() {
+		t.Errorf("Reminder came back after !ack and reconnecting")
+	}
+}
@@ -0,0 +1,88 @@
+package ledsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"foubot2/configuration"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the body, see
+// configuration.Webhook.
+const signatureHeader = "X-Foubot2-Signature"
+
+// runWebhooks queues the webhooks configured for event, each on its own
+// worker. calendarEvent is the event which started, for "event_start".
+func (ss *SWITCHSTATE) runWebhooks(cfg *configuration.Config, nc *http.Client, event, calendarEvent string) {
+	ss.mu.Lock()
+	open, since, source := ss.status, ss.since, ss.source
+	ss.mu.Unlock()
+
+	for _, w := range cfg.Webhooks {
+		if !w.RunsOn(event) {
+			continue
+		}
+		w := w
+		nc := &http.Client{Transport: nc.Transport, Timeout: webhookTimeout(w)}
+		ss.sinks.run("webhook_"+w.Name, func() {
+			body := w.Body.Render(map[string]string{
+				"Event":         event,
+				"Status":        statusString(open),
+				"Open":          strconv.FormatBool(open),
+				"Since":         since.Format(time.RFC3339),
+				"Source":        source,
+				"CalendarEvent": calendarEvent,
+				"Time":          time.Now().Format(time.RFC3339),
+			})
+			sendWebhook(nc, w, body)
+		})
+	}
+}
+
+func webhookTimeout(w configuration.Webhook) time.Duration {
+	if w.Timeout == 0 {
+		return configuration.DefaultSinkTimeout
+	}
+	return time.Duration(w.Timeout)
+}
+
+func sendWebhook(nc *http.Client, w configuration.Webhook, body string) {
+	sink := "webhook_" + w.Name
+	start := time.Now()
+	req, err := http.NewRequest(w.Method, w.URL.Value(), strings.NewReader(body))
+	if err != nil {
+		err = withoutURL(err)
+		log.Printf("Webhook %s error: %s", w.Name, err)
+		recordResult(sink, start, "", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value.Value())
+	}
+	if key := w.Secret.Value(); key != "" {
+		req.Header.Set(signatureHeader, "sha256="+sign(key, body))
+	}
+
+	resp, err := nc.Do(req)
+	if err != nil {
+		err = withoutURL(err)
+		log.Printf("Webhook %s error: %s", w.Name, err)
+	} else {
+		log.Printf("Webhook %s: %s", w.Name, resp.Status)
+	}
+	recordHTTP(sink, start, resp, err)
+}
+
+// sign returns the hex HMAC-SHA256 of body with key.
+func sign(key, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
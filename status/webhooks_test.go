@@ -0,0 +1,92 @@
+package ledsign
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"foubot2/configuration"
+)
+
+func TestWebhooks(t *testing.T) {
+	type request struct {
+		method, auth, contentType, signature, body string
+	}
+	var mu sync.Mutex
+	var got []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, request{r.Method, r.Header.Get("Authorization"), r.Header.Get("Content-Type"), r.Header.Get(signatureHeader), string(b)})
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	withTestConfig(t, `{"blinker": "", "gpio": {"backend": "fake", "debounce": "0s"}, "webhooks": [{
+		"name": "site",
+		"on": ["closed"],
+		"method": "PUT",
+		"url": "`+srv.URL+`/status",
+		"headers": {"Authorization": "Bearer t0ken"},
+		"body": "{\"status\": {{json .Status}}, \"open\": {{.Open}}, \"source\": {{json .Source}}}",
+		"secret": "s3cret"
+	}]}`)
+
+	gpio := NewFakeGPIO()
+	startOpen(t, gpio)
+	gpio.SetInput(23, false)
+
+	waitFor(t, "webhook", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) > 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("Requests: got %+v, want 1 (only on closed)", got)
+	}
+	body := `{"status": "CLOSED", "open": false, "source": "button"}`
+	want := request{"PUT", "Bearer t0ken", "application/json", "sha256=" + sign("s3cret", body), body}
+	if got[0] != want {
+		t.Errorf("Request: got %+v, want %+v", got[0], want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// From RFC 4231, test case 2.
+	got := sign("Jefe", "what do ya want for nothing?")
+	want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("sign: got %s, want %s", got, want)
+	}
+}
+
+func TestWebhookErrorWithoutURL(t *testing.T) {
+	withTestConfig(t, `{"webhooks": [{"name": "down", "on": ["open"], "url": "http://127.0.0.1:1/s3cret"}]}`)
+	cfg := configuration.Get()
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	sendWebhook(&http.Client{}, cfg.Webhooks[0], "{}")
+	if strings.Contains(logged.String(), "s3cret") {
+		t.Errorf("Log: got %q, want no URL", logged.String())
+	}
+	for _, r := range LastResults() {
+		if r.Sink != "webhook_down" {
+			continue
+		}
+		if r.Err == nil || strings.Contains(r.Err.Error(), "s3cret") {
+			t.Errorf("Result: got %v, want an error without the URL", r.Err)
+		}
+		return
+	}
+	t.Errorf("Result: got none for webhook_down")
+}
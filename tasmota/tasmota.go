@@ -0,0 +1,127 @@
+// Package tasmota sends commands to Tasmota devices (smart plugs, lights) over
+// their HTTP API (https://tasmota.github.io/docs/Commands/#with-web-requests).
+package tasmota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"foubot2/httperr"
+)
+
+// Client talks to one device.
+type Client struct {
+	// The device, eg. "http://blinker.lab/".
+	URL string
+	// The web admin password, empty if there is none.
+	Password   string
+	HTTPClient *http.Client
+}
+
+// Response is the JSON reply to a command, eg. {"POWER": "ON"} or
+// {"Dimmer": 50, "Color": "FF0000"}.
+type Response map[string]interface{}
+
+// Command sends cmd, eg. "Power On" or "Color 255,0,0", and returns the reply.
+// Commands which the device rejects are errors.
+func (c *Client) Command(cmd string) (Response, error) {
+	v := url.Values{"cmnd": {cmd}}
+	if c.Password != "" {
+		v.Set("user", "admin")
+		v.Set("password", c.Password)
+	}
+	u := strings.TrimSuffix(c.URL, "/") + "/cm?" + strings.ReplaceAll(v.Encode(), "+", "%20")
+
+	resp, err := c.HTTPClient.Get(u)
+	if err != nil {
+		// Don't leak the password in the URL.
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return nil, fmt.Errorf("%s: %w", cmd, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%s: wrong password", cmd)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %w", cmd, httperr.Unexpected(resp))
+	}
+
+	var r Response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("%s: reply is not JSON: %q", cmd, b)
+	}
+	// Unknown commands and bad arguments are still 200.
+	if v, ok := r["Command"]; ok {
+		return r, fmt.Errorf("%s: %v", cmd, v)
+	}
+	if v, ok := r["WARNING"]; ok {
+		return r, fmt.Errorf("%s: %v", cmd, v)
+	}
+	return r, nil
+}
+
+// Power returns whether the (first) relay is on.
+func (c *Client) Power() (bool, error) {
+	r, err := c.Command("Power")
+	if err != nil {
+		return false, err
+	}
+	return r.power()
+}
+
+// SetPower switches the (first) relay on or off, and checks that the device
+// says it did.
+func (c *Client) SetPower(on bool) error {
+	cmd := "Power Off"
+	if on {
+		cmd = "Power On"
+	}
+	r, err := c.Command(cmd)
+	if err != nil {
+		return err
+	}
+	got, err := r.power()
+	if err != nil {
+		return fmt.Errorf("%s: %s", cmd, err)
+	}
+	if got != on {
+		return fmt.Errorf("%s: device reports power %s", cmd, OnOff(got))
+	}
+	return nil
+}
+
+// power reads the relay state from a reply: devices with one relay say
+// "POWER", with several "POWER1", "POWER2"...
+func (r Response) power() (bool, error) {
+	for _, key := range []string{"POWER", "POWER1"} {
+		if v, ok := r[key]; ok {
+			switch v {
+			case "ON":
+				return true, nil
+			case "OFF":
+				return false, nil
+			}
+			return false, fmt.Errorf("unexpected power state %v", v)
+		}
+	}
+	return false, fmt.Errorf("no power state in reply %v", map[string]interface{}(r))
+}
+
+// OnOff is a power state as the device writes it: "ON" or "OFF".
+func OnOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
@@ -0,0 +1,77 @@
+package tasmota
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeDevice answers like a Tasmota plug with one relay.
+type fakeDevice struct {
+	power    string
+	password string
+	// Stuck devices ignore Power commands.
+	stuck    bool
+	commands []string
+}
+
+func (d *fakeDevice) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/cm" {
+		http.NotFound(w, r)
+		return
+	}
+	if d.password != "" && (r.FormValue("user") != "admin" || r.FormValue("password") != d.password) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	cmd := r.FormValue("cmnd")
+	d.commands = append(d.commands, cmd)
+	switch {
+	case cmd == "Power":
+	case cmd == "Power On" || cmd == "Power Off":
+		if !d.stuck {
+			d.power = strings.ToUpper(strings.TrimPrefix(cmd, "Power "))
+		}
+	case strings.HasPrefix(cmd, "Dimmer "):
+		fmt.Fprintf(w, `{"Dimmer": %s}`, strings.TrimPrefix(cmd, "Dimmer "))
+		return
+	default:
+		fmt.Fprint(w, `{"Command": "Unknown"}`)
+		return
+	}
+	fmt.Fprintf(w, `{"POWER": %q}`, d.power)
+}
+
+func TestClient(t *testing.T) {
+	d := &fakeDevice{power: "OFF", password: "s3cret"}
+	hs := httptest.NewServer(d)
+	defer hs.Close()
+	c := &Client{URL: hs.URL + "/", Password: "s3cret", HTTPClient: hs.Client()}
+
+	if err := c.SetPower(true); err != nil {
+		t.Fatalf("SetPower: %s", err)
+	}
+	on, err := c.Power()
+	if err != nil || !on {
+		t.Errorf("Power: got %v, %v, want on", on, err)
+	}
+	r, err := c.Command("Dimmer 50")
+	if err != nil || r["Dimmer"] != 50.0 {
+		t.Errorf("Dimmer 50: got %v, %v", r, err)
+	}
+	if _, err := c.Command("Blinky 3"); err == nil || !strings.Contains(err.Error(), "Unknown") {
+		t.Errorf("Blinky 3: got %v, want Unknown", err)
+	}
+
+	d.stuck = true
+	if err := c.SetPower(false); err == nil || !strings.Contains(err.Error(), "device reports power ON") {
+		t.Errorf("SetPower stuck: got %v, want device reports power ON", err)
+	}
+
+	c.Password = "wrong"
+	if _, err := c.Power(); err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Errorf("Power with wrong password: got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"foubot2/configuration"
+	"foubot2/status"
+)
+
+// The read-only JSON API under /api/.
+
+type apiStatus struct {
+	Open bool `json:"open"`
+	// When the status began.
+	Since time.Time `json:"since"`
+	// Seconds since then.
+	Duration int64 `json:"duration"`
+	// Set with !open or !close, null if the status follows the button.
+	Override *apiOverride `json:"override"`
+	// The outcome of the last update of every output, as in /api/sinks.
+	Sinks []apiSinkResult `json:"sinks"`
+}
+
+type apiOverride struct {
+	By string `json:"by"`
+	// Null if it doesn't expire.
+	Until *time.Time `json:"until"`
+}
+
+type apiIRC struct {
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+	Server  string    `json:"server"`
+	Channel string    `json:"channel"`
+	Nick    string    `json:"nick"`
+	// Empty until the channel is joined.
+	Topic string `json:"topic"`
+}
+
+type apiEvent struct {
+	Summary string     `json:"summary"`
+	Start   time.Time  `json:"start"`
+	End     *time.Time `json:"end,omitempty"`
+}
+
+type apiSinkResult struct {
+	Sink   string    `json:"sink"`
+	Time   time.Time `json:"time"`
+	OK     bool      `json:"ok"`
+	Detail string    `json:"detail,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	lab := s.bot.Lab()
+	if lab == nil {
+		notConnected(w)
+		return
+	}
+	since := lab.GetSince()
+	resp := apiStatus{
+		Open:     lab.GetSwitchStatus(),
+		Since:    since,
+		Duration: int64(time.Since(since) / time.Second),
+		Sinks:    sinkResults(),
+	}
+	if o := lab.GetOverride(); o != nil {
+		resp.Override = &apiOverride{By: o.By}
+		if !o.Until.IsZero() {
+			resp.Override.Until = &o.Until
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleIRC(w http.ResponseWriter, r *http.Request) {
+	cfg := configuration.Get()
+	state := s.bot.IRC()
+	resp := apiIRC{
+		State:   state.State,
+		Since:   state.Since,
+		Server:  cfg.IRC.Server,
+		Channel: cfg.IRC.Channel,
+		Nick:    cfg.IRC.Nick,
+	}
+	if lab := s.bot.Lab(); lab != nil {
+		resp.Topic = lab.GetTopic()
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	lab := s.bot.Lab()
+	if lab == nil {
+		notConnected(w)
+		return
+	}
+	events := []apiEvent{}
+	for _, e := range lab.Upcoming() {
+		ev := apiEvent{Summary: e.Summary, Start: e.Start}
+		if !e.End.IsZero() {
+			end := e.End
+			ev.End = &end
+		}
+		events = append(events, ev)
+	}
+	writeJSON(w, events)
+}
+
+func (s *Server) handleSinks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, sinkResults())
+}
+
+func sinkResults() []apiSinkResult {
+	results := []apiSinkResult{}
+	for _, res := range ledsign.LastResults() {
+		ar := apiSinkResult{Sink: res.Sink, Time: res.Time, OK: res.Err == nil, Detail: res.Detail}
+		if res.Err != nil {
+			ar.Error = res.Err.Error()
+		}
+		results = append(results, ar)
+	}
+	return results
+}
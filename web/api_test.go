@@ -0,0 +1,87 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"foubot2/status"
+)
+
+func TestAPI(t *testing.T) {
+	withTestConfig(t, `{"irc": {"channel": "#test"}}`)
+
+	since := time.Now().Add(-time.Hour).Truncate(time.Second)
+	start := since.Add(24 * time.Hour)
+	b := &fakeBot{
+		lab: &fakeLab{
+			open:     true,
+			since:    since,
+			topic:    "Foulab || LAB OPEN ||",
+			upcoming: []ledsign.Event{{Summary: "Soldering", Start: start}},
+		},
+		irc: IRCState{State: "joined", Since: since},
+	}
+	s := NewServer(b)
+
+	var status apiStatus
+	getJSON(t, s, "/api/status", &status)
+	if !status.Open || !status.Since.Equal(since) || status.Duration < 3600 {
+		t.Errorf("/api/status: got %+v, want open since %s", status, since)
+	}
+	if status.Sinks == nil {
+		t.Errorf("/api/status: got null sinks, want a list")
+	}
+
+	var irc apiIRC
+	getJSON(t, s, "/api/irc", &irc)
+	if irc.State != "joined" || irc.Channel != "#test" || irc.Topic != "Foulab || LAB OPEN ||" {
+		t.Errorf("/api/irc: got %+v, want joined #test with the topic", irc)
+	}
+
+	var events []apiEvent
+	getJSON(t, s, "/api/events", &events)
+	if len(events) != 1 || events[0].Summary != "Soldering" || !events[0].Start.Equal(start) || events[0].End != nil {
+		t.Errorf("/api/events: got %+v, want Soldering at %s", events, start)
+	}
+
+	var sinks []apiSinkResult
+	getJSON(t, s, "/api/sinks", &sinks)
+	if sinks == nil {
+		t.Errorf("/api/sinks: got null, want a list")
+	}
+
+	// Not connected: the IRC state is known, the lab status isn't.
+	b.lab = nil
+	b.irc = IRCState{State: "disconnected", Since: since}
+	getJSON(t, s, "/api/irc", &irc)
+	if irc.State != "disconnected" || irc.Topic != "" {
+		t.Errorf("/api/irc: got %+v, want disconnected without topic", irc)
+	}
+	for _, path := range []string{"/api/status", "/api/events"} {
+		if w := get(t, s, path); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: got %d, want %d", path, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+
+	if w := get(t, s, "/metrics"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "# TYPE foubot2_sink_requests_total counter\n") {
+		t.Errorf("/metrics: got %d %q, want the sink metrics", w.Code, w.Body.String())
+	}
+
+	if w := get(t, s, "/api/nope"); w.Code != http.StatusNotFound {
+		t.Errorf("/api/nope: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func getJSON(t *testing.T, s *Server, path string, v interface{}) {
+	t.Helper()
+	w := get(t, s, path)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: got %d, want %d", path, w.Code, http.StatusOK)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+}
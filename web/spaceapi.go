@@ -0,0 +1,90 @@
+package web
+
+import (
+	"net/http"
+
+	"foubot2/configuration"
+)
+
+// SpaceAPI v14 and v15 document, https://spaceapi.io/docs/
+type spaceAPI struct {
+	APICompatibility []string          `json:"api_compatibility"`
+	Space            string            `json:"space"`
+	Logo             string            `json:"logo"`
+	URL              string            `json:"url"`
+	Location         spaceAPILocation  `json:"location"`
+	Contact          map[string]string `json:"contact"`
+	State            spaceAPIState     `json:"state"`
+	Feeds            *spaceAPIFeeds    `json:"feeds,omitempty"`
+	Projects         []string          `json:"projects,omitempty"`
+}
+
+type spaceAPILocation struct {
+	Address  string  `json:"address,omitempty"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Timezone string  `json:"timezone,omitempty"`
+}
+
+type spaceAPIState struct {
+	Open       bool          `json:"open"`
+	LastChange int64         `json:"lastchange"`
+	Icon       *spaceAPIIcon `json:"icon,omitempty"`
+}
+
+type spaceAPIIcon struct {
+	Open   string `json:"open"`
+	Closed string `json:"closed"`
+}
+
+type spaceAPIFeeds struct {
+	Calendar spaceAPIFeed `json:"calendar"`
+}
+
+type spaceAPIFeed struct {
+	Type string `json:"type,omitempty"`
+	URL  string `json:"url"`
+}
+
+func (s *Server) handleSpaceAPI(w http.ResponseWriter, r *http.Request) {
+	cfg := configuration.Get()
+	if cfg.SpaceAPI.Space == "" {
+		http.NotFound(w, r)
+		return
+	}
+	lab := s.bot.Lab()
+	if lab == nil {
+		notConnected(w)
+		return
+	}
+	writeJSON(w, newSpaceAPI(cfg, lab))
+}
+
+func newSpaceAPI(cfg *configuration.Config, lab Lab) *spaceAPI {
+	c := cfg.SpaceAPI
+	doc := &spaceAPI{
+		APICompatibility: []string{"14", "15"},
+		Space:            c.Space,
+		Logo:             c.Logo,
+		URL:              c.URL,
+		Location: spaceAPILocation{
+			Address:  c.Location.Address,
+			Lat:      c.Location.Lat,
+			Lon:      c.Location.Lon,
+			Timezone: c.Location.Timezone,
+		},
+		Contact: c.Contact,
+		State: spaceAPIState{
+			Open:       lab.GetSwitchStatus(),
+			LastChange: lab.GetSince().Unix(),
+		},
+		Projects: c.Projects,
+	}
+	if c.IconOpen != "" {
+		doc.State.Icon = &spaceAPIIcon{Open: c.IconOpen, Closed: c.IconClosed}
+	}
+	if cfg.Calendar.URL != "" {
+		doc.Feeds = &spaceAPIFeeds{Calendar: spaceAPIFeed{Type: "ical", URL: cfg.Calendar.URL}}
+	}
+	return doc
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"foubot2/configuration"
+	"foubot2/status"
+)
+
+type fakeLab struct {
+	open     bool
+	since    time.Time
+	topic    string
+	override *ledsign.Override
+	upcoming []ledsign.Event
+}
+
+func (l *fakeLab) GetSwitchStatus() bool          { return l.open }
+func (l *fakeLab) GetSince() time.Time            { return l.since }
+func (l *fakeLab) GetTopic() string               { return l.topic }
+func (l *fakeLab) GetOverride() *ledsign.Override { return l.override }
+func (l *fakeLab) Upcoming() []ledsign.Event      { return l.upcoming }
+
+type fakeBot struct {
+	// Not connected if nil.
+	lab *fakeLab
+	irc IRCState
+}
+
+func (b *fakeBot) Lab() Lab {
+	if b.lab == nil {
+		return nil
+	}
+	return b.lab
+}
+
+func (b *fakeBot) IRC() IRCState { return b.irc }
+
+// withTestConfig puts config in effect for the duration of the test.
+func withTestConfig(t *testing.T, config string) {
+	old := configuration.Get()
+	t.Cleanup(func() { configuration.Set(old) })
+
+	cfg, err := configuration.Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.Set(cfg)
+}
+
+func get(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+const spaceAPIConfig = `{"spaceapi": {
+	"space": "Foulab",
+	"logo": "https://example.org/logo.png",
+	"url": "https://example.org/",
+	"location": {"lat": 45.5, "lon": -73.6},
+	"contact": {"irc": "ircs://irc.libera.chat:6697/#foulab"}
+}}`
+
+func TestSpaceAPI(t *testing.T) {
+	withTestConfig(t, spaceAPIConfig)
+
+	since := time.Date(2025, 1, 2, 19, 42, 0, 0, time.UTC)
+	lab := &fakeLab{open: true, since: since}
+	s := NewServer(&fakeBot{lab: lab})
+
+	w := get(t, s, "/spaceapi.json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type: got %q, want %q", got, "application/json")
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"api_compatibility", "space", "logo", "url", "location", "contact", "state"} {
+		if _, ok := doc[field]; !ok {
+			t.Errorf("Missing required field %q", field)
+		}
+	}
+	wantState := map[string]interface{}{"open": true, "lastchange": float64(since.Unix())}
+	if got := doc["state"]; !reflect.DeepEqual(got, wantState) {
+		t.Errorf("state: got %v, want %v", got, wantState)
+	}
+	if _, ok := doc["events"]; ok {
+		t.Errorf("events: got %v, want none", doc["events"])
+	}
+}
+
+func TestSpaceAPIUnavailable(t *testing.T) {
+	withTestConfig(t, spaceAPIConfig)
+	s := NewServer(&fakeBot{})
+	if w := get(t, s, "/spaceapi.json"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Not connected: got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	withTestConfig(t, `{}`)
+	s = NewServer(&fakeBot{lab: &fakeLab{}})
+	if w := get(t, s, "/spaceapi.json"); w.Code != http.StatusNotFound {
+		t.Errorf("Not configured: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
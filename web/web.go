@@ -0,0 +1,86 @@
+// Package web is foubot2's built-in HTTP server.
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"foubot2/metrics"
+	"foubot2/status"
+)
+
+// Bot is what the HTTP server reports on, see package main.
+type Bot interface {
+	// Lab returns the status of the current IRC connection, nil while not
+	// connected.
+	Lab() Lab
+	IRC() IRCState
+}
+
+// Lab is the live lab status, see ledsign.SWITCHSTATE.
+type Lab interface {
+	GetSwitchStatus() bool
+	GetSince() time.Time
+	GetTopic() string
+	GetOverride() *ledsign.Override
+	Upcoming() []ledsign.Event
+}
+
+// IRCState is the state of the connection to IRC.
+type IRCState struct {
+	// "connecting", "connected" (registered), "joined" or "disconnected".
+	State string
+	// When State last changed.
+	Since time.Time
+}
+
+// Server serves the HTTP endpoints. The configuration is read on every
+// request, so that reloading it applies right away.
+type Server struct {
+	bot Bot
+	mux *http.ServeMux
+}
+
+func NewServer(bot Bot) *Server {
+	s := &Server{bot: bot, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/spaceapi.json", s.handleSpaceAPI)
+	s.mux.HandleFunc("/api/status", s.handleStatus)
+	s.mux.HandleFunc("/api/irc", s.handleIRC)
+	s.mux.HandleFunc("/api/events", s.handleEvents)
+	s.mux.HandleFunc("/api/sinks", s.handleSinks)
+	s.mux.Handle("/metrics", metrics.Handler())
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// writeJSON sends v, readable from other sites' scripts. Lists in v should be
+// empty rather than nil, to send [] rather than null.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		log.Printf("HTTP: encode JSON: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(append(b, '\n'))
+}
+
+// notConnected answers while the lab status is unknown, between IRC
+// connections.
+func notConnected(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, "lab status not known yet, try again later", http.StatusServiceUnavailable)
+}